@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"errors"
-	"net/http"
+	"fmt"
+	"maps"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -15,12 +20,17 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/enrich"
 	"github.com/hakastein/gospy/internal/obfuscation"
 	"github.com/hakastein/gospy/internal/parser"
+	"github.com/hakastein/gospy/internal/phpspy"
 	"github.com/hakastein/gospy/internal/profiler"
 	"github.com/hakastein/gospy/internal/pyroscope"
+	"github.com/hakastein/gospy/internal/stack"
 	"github.com/hakastein/gospy/internal/supervisor"
 	"github.com/hakastein/gospy/internal/tag"
+	"github.com/hakastein/gospy/internal/transform"
+	"github.com/hakastein/gospy/internal/validator"
 	"github.com/hakastein/gospy/internal/version"
 )
 
@@ -40,55 +50,195 @@ func setupLogger(verbose int, instanceName string) {
 
 func run(ctx context.Context, cancel context.CancelFunc, c *cli.Context) error {
 	var (
-		pyroscopeURL                     = c.String("pyroscope")
-		pyroscopeAuth                    = c.String("pyroscope-auth")
-		pyroscopeWorkers                 = c.Int("pyroscope-workers")
-		pyroscopeTimeout                 = c.Duration("pyroscope-timeout")
-		tagEntrypoint                    = c.Bool("tag-entrypoint")
-		keepEntrypointName               = c.Bool("keep-entrypoint-name")
-		appName                          = c.String("app")
-		restart                          = c.String("restart")
-		rateLimit                        = int(c.Float64("rate-mb") * Megabyte)
-		rateBurst                        = int(c.Float64("rate-burst-mb") * Megabyte)
-		appTags                          = c.StringSlice("tag")
-		staticTags, dynamicTags, tagsErr = tag.ParseInput(appTags)
-		entryPoints                      = c.StringSlice("entrypoint")
-		statsInterval                    = c.Duration("stats-interval")
-		arguments                        = c.Args().Slice()
+		pyroscopeURL        = c.String("pyroscope")
+		pyroscopeAuth       = c.String("pyroscope-auth")
+		pyroscopeBasicAuth  = c.String("pyroscope-basic-auth")
+		pyroscopeTenantID   = c.String("pyroscope-tenant-id")
+		pyroscopeHeaders    = c.StringSlice("pyroscope-header")
+		destinationInputs   = c.StringSlice("pyroscope-destination")
+		pyroscopeWorkers    = c.Int("pyroscope-workers")
+		pyroscopeTimeout    = c.Duration("pyroscope-timeout")
+		tagEntrypoint       = c.Bool("tag-entrypoint")
+		tagPid              = c.Bool("tag-pid")
+		tagFpmPool          = c.Bool("tag-fpm-pool")
+		tagContainer        = c.Bool("tag-container")
+		injectProfilerFlags = c.Bool("inject-profiler-flags")
+		podInfoDir          = c.String("podinfo-dir")
+		keepEntrypointName  = c.Bool("keep-entrypoint-name")
+		frameLines          = c.String("frame-lines")
+		frameRules          = c.StringSlice("frame-rule")
+		maxDepth            = c.Int("max-depth")
+		focus               = c.StringSlice("focus")
+		ignore              = c.StringSlice("ignore")
+		pathStripPrefixes   = c.StringSlice("path-strip-prefix")
+		pathRewrites        = c.StringSlice("path-rewrite")
+		pathAliases         = c.StringSlice("path-alias")
+		appName             = c.String("app")
+		restart             = c.String("restart")
+		rateLimit           = int(c.Float64("rate-mb") * pyroscope.Megabyte)
+		rateBurst           = int(c.Float64("rate-burst-mb") * pyroscope.Megabyte)
+		appTags             = c.StringSlice("tag")
+		entryPoints         = c.StringSlice("entrypoint")
+		entryPointSamples   = c.StringSlice("entrypoint-sample")
+		appRoutes           = c.StringSlice("app-route")
+		statsInterval       = c.Duration("stats-interval")
+		arguments           = c.Args().Slice()
 	)
 
+	if len(arguments) == 0 {
+		return errors.New("no profiler application specified")
+	}
+
+	if patternsError := validator.Validate(entryPoints); patternsError != nil {
+		return patternsError
+	}
+
+	compression, compressionError := pyroscope.ParseCompression(c.String("pyroscope-compression"))
+	if compressionError != nil {
+		return compressionError
+	}
+
+	destinations, destinationsError := pyroscopeDestinations(
+		pyroscope.Destination{
+			Name:        "default",
+			URL:         pyroscopeURL,
+			AuthToken:   pyroscopeAuth,
+			BasicAuth:   pyroscopeBasicAuth,
+			TenantID:    pyroscopeTenantID,
+			Workers:     pyroscopeWorkers,
+			RateLimit:   rateLimit,
+			RateBurst:   rateBurst,
+			Compression: compression,
+			BatchSize:   int(c.Float64("pyroscope-batch-mb") * pyroscope.Megabyte),
+		},
+		destinationInputs,
+	)
+	if destinationsError != nil {
+		return destinationsError
+	}
+
+	pyroscopeTransport := pyroscope.TransportConfig{
+		Timeout:             pyroscopeTimeout,
+		CertFile:            c.String("pyroscope-tls-cert"),
+		KeyFile:             c.String("pyroscope-tls-key"),
+		CAFile:              c.String("pyroscope-tls-ca"),
+		ServerName:          c.String("pyroscope-tls-server-name"),
+		InsecureSkipVerify:  c.Bool("pyroscope-tls-insecure-skip-verify"),
+		ProxyURL:            c.String("pyroscope-proxy"),
+		MaxIdleConns:        c.Int("pyroscope-max-idle-conns"),
+		MaxIdleConnsPerHost: c.Int("pyroscope-max-idle-conns-per-host"),
+		MaxConnsPerHost:     c.Int("pyroscope-max-conns-per-host"),
+		IdleConnTimeout:     c.Duration("pyroscope-idle-conn-timeout"),
+	}
+	httpClient, transportError := pyroscope.NewHTTPClient(pyroscopeTransport)
+	if transportError != nil {
+		return transportError
+	}
+
+	profilerApp := arguments[0]
+	profilerArguments := arguments[1:]
+
+	profilerInstance, profilerError := profiler.Init(profilerApp, profilerArguments, profiler.Args{OutputFifo: c.Bool("output-fifo")})
+	if profilerError != nil {
+		return profilerError
+	}
+
+	// Without --pid phpspy samples processes found by pgrep or the command it runs, which may run in other containers
+	// than gospy, so the container id is resolved from the pid of each sample instead of being a static tag
+	userContainerID := slices.ContainsFunc(appTags, func(appTag string) bool {
+		return strings.HasPrefix(appTag, enrich.ContainerIDTag+"=")
+	})
+	resolveContainers := tagContainer && profilerInstance.GetPID() == 0 && !userContainerID
+	if tagContainer {
+		enricher := enrich.New()
+		enricher.PodInfoDir = podInfoDir
+		enricher.SkipContainerID = resolveContainers
+		appTags = enricher.Apply(appTags, profilerInstance.GetPID())
+	}
+
+	staticTags, dynamicTags, tagsErr := tag.ParseInput(appTags)
 	if tagsErr != nil {
 		return tagsErr
 	}
 
-	if len(arguments) == 0 {
-		return errors.New("no profiler application specified")
+	var requestTags []string
+	for _, requestTag := range phpspy.RequestTags {
+		if c.Bool("tag-request-" + requestTag.Name) {
+			requestTags = append(requestTags, requestTag.Name)
+		}
+	}
+	if requestTagsErr := phpspy.AddRequestTags(requestTags, dynamicTags); requestTagsErr != nil {
+		return requestTagsErr
+	}
+
+	// Check that the profiler emits metadata for every dynamic tag
+	metaKeys := slices.Collect(maps.Keys(dynamicTags))
+	if tagPid || tagFpmPool || resolveContainers {
+		metaKeys = append(metaKeys, "pid")
+	}
+	if metaErr := profilerInstance.EnsureMetaSources(metaKeys, injectProfilerFlags); metaErr != nil {
+		return metaErr
 	}
 
 	log.Info().
-		Str("pyroscope_url", pyroscopeURL).
-		Str("pyroscope_auth", obfuscation.MaskString(pyroscopeAuth, 4, 2)).
+		Strs("pyroscope_headers", maskHeaders(pyroscopeHeaders)).
+		Str("pyroscope_tls_cert", pyroscopeTransport.CertFile).
+		Str("pyroscope_tls_ca", pyroscopeTransport.CAFile).
+		Bool("pyroscope_tls_insecure_skip_verify", pyroscopeTransport.InsecureSkipVerify).
+		Str("pyroscope_proxy", redactURL(pyroscopeTransport.ProxyURL)).
 		Str("app_name", appName).
 		Bool("tag_entrypoint", tagEntrypoint).
+		Strs("request_tags", requestTags).
+		Bool("tag_pid", tagPid).
+		Bool("tag_fpm_pool", tagFpmPool).
+		Bool("tag_container", tagContainer).
 		Bool("keep_entrypoint_name", keepEntrypointName).
+		Str("frame_lines", frameLines).
+		Strs("frame_rules", frameRules).
+		Int("max_depth", maxDepth).
+		Strs("focus", focus).
+		Strs("ignore", ignore).
+		Strs("path_strip_prefixes", pathStripPrefixes).
+		Strs("path_rewrites", pathRewrites).
+		Strs("path_aliases", pathAliases).
+		Strs("entrypoint_samples", entryPointSamples).
+		Strs("app_routes", appRoutes).
 		Str("restart", restart).
-		Int("rate_bytes", rateLimit).
-		Int("rate_burst", rateBurst).
 		Str("version", version.Get()).
 		Strs("tags", appTags).
 		Msg("gospy started")
 
+	clients := make([]*pyroscope.Client, 0, len(destinations))
+	for _, destination := range destinations {
+		log.Info().
+			Str("name", destination.Name).
+			Str("url", destination.URL).
+			Str("auth", obfuscation.MaskString(destination.AuthToken, 4, 2)).
+			Str("basic_auth", obfuscation.MaskString(destination.BasicAuth, 4, 2)).
+			Str("tenant_id", destination.TenantID).
+			Int("workers", destination.Workers).
+			Int("rate_bytes", destination.RateLimit).
+			Int("rate_burst", destination.RateBurst).
+			Stringer("compression", destination.Compression).
+			Int("batch_bytes", destination.BatchSize).
+			Msg("pyroscope destination")
+
+		clientOptions, authToken, clientError := pyroscopeClientOptions(
+			destination.AuthToken,
+			destination.BasicAuth,
+			destination.TenantID,
+			pyroscopeHeaders,
+		)
+		if clientError != nil {
+			return fmt.Errorf("destination %s: %w", destination.Name, clientError)
+		}
+		clientOptions = append(clientOptions, pyroscope.WithCompression(destination.Compression))
+		clients = append(clients, pyroscope.NewClient(destination.URL, authToken, httpClient, clientOptions...))
+	}
+
 	stacksChannel := make(chan *collector.Sample, 1000)
 	signalsChannel := make(chan os.Signal, 1)
-	statsChannel := make(chan *pyroscope.RequestStats, 1000)
 
-	profilerApp := arguments[0]
-	profilerArguments := arguments[1:]
-
-	profilerInstance, profilerError := profiler.Init(profilerApp, profilerArguments)
-	if profilerError != nil {
-		return profilerError
-	}
 	// Terminate app if profiler arguments aren't supported by gospy
 	if sup, unsupportableError := profilerInstance.IsConfigurationValid(); !sup {
 		return unsupportableError
@@ -96,12 +246,73 @@ func run(ctx context.Context, cancel context.CancelFunc, c *cli.Context) error {
 	// Get sample rate from profiler settings
 	samplingRateHZ := profilerInstance.GetHZ()
 
+	lineMode, lineModeError := transform.ParseLineMode(frameLines)
+	if lineModeError != nil {
+		return lineModeError
+	}
+
+	rules, rulesError := transform.ParseFrameRules(frameRules)
+	if rulesError != nil {
+		return rulesError
+	}
+
+	// Stacks are interned once and shared by the parser and trace collectors of all destinations
+	stackTables := stack.NewTables(stack.DefaultMaxStacks)
+
+	parserOptions := []phpspy.Option{
+		phpspy.WithStackTables(stackTables),
+		phpspy.WithLines(lineMode),
+		phpspy.WithFrameRules(rules),
+		phpspy.WithMaxDepth(maxDepth),
+		phpspy.WithStatsInterval(statsInterval),
+		phpspy.WithMaxLineSize(int(c.Float64("max-line-mb") * pyroscope.Megabyte)),
+	}
+	if len(focus) > 0 || len(ignore) > 0 {
+		filter, filterError := transform.NewStackFilter(focus, ignore)
+		if filterError != nil {
+			return filterError
+		}
+		parserOptions = append(parserOptions, phpspy.WithStackFilter(filter))
+	}
+	if len(entryPointSamples) > 0 {
+		samplingRules, samplingError := phpspy.ParseSamplingRules(entryPointSamples)
+		if samplingError != nil {
+			return samplingError
+		}
+		parserOptions = append(parserOptions, phpspy.WithSampling(phpspy.NewSampler(samplingRules)))
+	}
+
+	if len(appRoutes) > 0 {
+		routes, routesError := phpspy.ParseAppRoutes(appRoutes)
+		if routesError != nil {
+			return routesError
+		}
+		parserOptions = append(parserOptions, phpspy.WithAppRouter(phpspy.NewAppRouter(routes)))
+	}
+	if len(pathStripPrefixes) > 0 || len(pathRewrites) > 0 || len(pathAliases) > 0 {
+		paths, pathsError := transform.NewPathNormalizer(pathStripPrefixes, pathRewrites, pathAliases)
+		if pathsError != nil {
+			return pathsError
+		}
+		parserOptions = append(parserOptions, phpspy.WithPathNormalizer(paths))
+	}
+	if tagPid {
+		parserOptions = append(parserOptions, phpspy.WithPidTag())
+	}
+	if tagFpmPool {
+		parserOptions = append(parserOptions, phpspy.WithPoolTag(phpspy.NewPoolResolver(enrich.DefaultProcPath)))
+	}
+	if resolveContainers {
+		parserOptions = append(parserOptions, phpspy.WithContainerTag(phpspy.NewContainerResolver(enrich.DefaultProcPath)))
+	}
+
 	parserInstance, parserError := parser.Init(
-		profilerApp,
+		profilerInstance,
 		entryPoints,
 		dynamicTags,
 		tagEntrypoint,
 		keepEntrypointName,
+		parserOptions...,
 	)
 	if parserError != nil {
 		return parserError
@@ -136,36 +347,155 @@ func run(ctx context.Context, cancel context.CancelFunc, c *cli.Context) error {
 		)
 	}()
 
-	rateLimiter := rate.NewLimiter(rate.Limit(rateLimit), rateBurst)
-
-	// Trace collector is queue-like struct
-	traceCollector := collector.NewTraceCollector()
-	traceCollector.Subscribe(ctx, stacksChannel)
+	pyroscopeIngester := pyroscope.NewAppMetadata(appName, staticTags, samplingRateHZ)
 
-	httpClient := &http.Client{
-		Timeout: pyroscopeTimeout,
+	// Every destination has its own trace collector, so a slow or failing destination doesn't hold back the others
+	traceCollectors := make([]*collector.TraceCollector, 0, len(destinations))
+	for i, destination := range destinations {
+		traceCollectors = append(
+			traceCollectors,
+			startDestination(ctx, destination, clients[i], pyroscopeIngester, stackTables, statsInterval),
+		)
 	}
+	collector.SubscribeAll(ctx, stacksChannel, traceCollectors...)
 
-	pyroscopeClient := pyroscope.NewClient(
-		pyroscopeURL,
-		pyroscopeAuth,
-		httpClient,
-	)
+	wg.Wait()
+	<-ctx.Done()
+	log.Info().Msg("shutting down")
 
-	pyroscopeIngester := pyroscope.NewAppMetadata(appName, staticTags, samplingRateHZ)
-	statsAggregator := pyroscope.NewStatsAggregator(statsChannel, statsInterval)
+	return nil
+}
+
+// startDestination starts statistics and workers sending profiles to the destination
+// and returns the trace collector they consume.
+func startDestination(
+	ctx context.Context,
+	destination pyroscope.Destination,
+	client *pyroscope.Client,
+	appMetadata *pyroscope.AppMetadata,
+	stackTables *stack.Tables,
+	statsInterval time.Duration,
+) *collector.TraceCollector {
+	rateLimiter := rate.NewLimiter(rate.Limit(destination.RateLimit), destination.RateBurst)
+
+	// Trace collector is queue-like struct
+	traceCollector := collector.NewTraceCollector(collector.WithStackTables(stackTables))
 
+	statsChannel := make(chan *pyroscope.RequestStats, 1000)
+	statsAggregator := pyroscope.NewStatsAggregator(destination.Name, statsChannel, statsInterval)
 	statsAggregator.Start(ctx)
 
-	for workerNumber := 1; workerNumber <= pyroscopeWorkers; workerNumber++ {
+	for workerNumber := 1; workerNumber <= destination.Workers; workerNumber++ {
 		// each worker will consume traces by tag from the traceCollector queue
-		sender := pyroscope.NewWorker(pyroscopeClient, pyroscopeIngester, traceCollector, rateLimiter, statsChannel)
+		sender := pyroscope.NewWorker(client, appMetadata, traceCollector, rateLimiter, destination.BatchSize, statsChannel)
 		sender.Start(ctx)
 	}
 
-	wg.Wait()
-	<-ctx.Done()
-	log.Info().Msg("shutting down")
+	return traceCollector
+}
 
-	return nil
+// pyroscopeDestinations returns the destination set by --pyroscope flags, if its url is set,
+// followed by destinations set by --pyroscope-destination, which take workers, rate limits, compression and batching from it.
+func pyroscopeDestinations(defaults pyroscope.Destination, inputs []string) ([]pyroscope.Destination, error) {
+	var destinations []pyroscope.Destination
+	if defaults.URL != "" {
+		if err := defaults.Validate(); err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, defaults)
+	}
+
+	names := make(map[string]bool, len(inputs)+1)
+	names[defaults.Name] = defaults.URL != ""
+	for _, input := range inputs {
+		destination, err := pyroscope.ParseDestination(input, pyroscope.Destination{
+			Workers:     defaults.Workers,
+			RateLimit:   defaults.RateLimit,
+			RateBurst:   defaults.RateBurst,
+			Compression: defaults.Compression,
+			BatchSize:   defaults.BatchSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if names[destination.Name] {
+			return nil, fmt.Errorf("duplicate pyroscope destination name %s", destination.Name)
+		}
+		names[destination.Name] = true
+		destinations = append(destinations, destination)
+	}
+
+	if len(destinations) == 0 {
+		return nil, errors.New("no pyroscope destination specified, use --pyroscope or --pyroscope-destination")
+	}
+
+	return destinations, nil
+}
+
+// pyroscopeClientOptions resolves Pyroscope credentials and headers and returns client options with the auth token.
+func pyroscopeClientOptions(
+	authToken string,
+	basicAuth string,
+	tenantID string,
+	headers []string,
+) ([]pyroscope.ClientOption, string, error) {
+	if authToken != "" && basicAuth != "" {
+		return nil, "", errors.New("--pyroscope-auth and --pyroscope-basic-auth can't be used together")
+	}
+
+	token, err := pyroscope.ReadSecret(authToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid pyroscope auth: %w", err)
+	}
+
+	var options []pyroscope.ClientOption
+
+	if basicAuth != "" {
+		credentials, err := pyroscope.ReadSecret(basicAuth)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid pyroscope basic auth: %w", err)
+		}
+		user, password, err := pyroscope.ParseBasicAuth(credentials)
+		if err != nil {
+			return nil, "", err
+		}
+		options = append(options, pyroscope.WithBasicAuth(user, password))
+	}
+
+	if tenantID != "" {
+		tenant, err := pyroscope.ReadSecret(tenantID)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid pyroscope tenant id: %w", err)
+		}
+		options = append(options, pyroscope.WithTenantID(tenant))
+	}
+
+	if len(headers) > 0 {
+		parsedHeaders, err := pyroscope.ParseHeaders(headers)
+		if err != nil {
+			return nil, "", err
+		}
+		options = append(options, pyroscope.WithHeaders(parsedHeaders))
+	}
+
+	return options, token, nil
+}
+
+// maskHeaders masks header values for logging.
+func maskHeaders(headers []string) []string {
+	masked := make([]string, 0, len(headers))
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		masked = append(masked, name+": "+obfuscation.MaskString(strings.TrimSpace(value), 4, 2))
+	}
+	return masked
+}
+
+// redactURL masks the password of a URL for logging.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return obfuscation.MaskString(rawURL, 4, 2)
+	}
+	return parsed.Redacted()
 }
@@ -3,6 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/hakastein/gospy/internal/enrich"
+	"github.com/hakastein/gospy/internal/phpspy"
+	"github.com/hakastein/gospy/internal/pyroscope"
+	"github.com/hakastein/gospy/internal/transform"
 	"github.com/hakastein/gospy/internal/version"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
@@ -11,9 +15,8 @@ import (
 )
 
 const (
-	DefaultRateMB        = 4       // Default ingestion rate limit in MB for Pyroscope
-	Megabyte             = 1048576 // Number of bytes in a megabyte
-	PyroscopeWorkers     = 5       // Amount of pyroscope senders
+	DefaultRateMB        = 4 // Default ingestion rate limit in MB for Pyroscope
+	PyroscopeWorkers     = 5 // Amount of pyroscope senders
 	PyroscopeTimeout     = 10 * time.Second
 	DefaultStatsInterval = 10 * time.Second
 )
@@ -53,19 +56,93 @@ func main() {
 		DisableSliceFlagSeparator: true,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "pyroscope",
-				Usage:    "Pyroscope server URL",
-				Required: true,
+				Name:  "pyroscope",
+				Usage: "Pyroscope server URL",
+			},
+			&cli.StringSliceFlag{
+				Name:  "pyroscope-destination",
+				Usage: "Additional Pyroscope destination (url=...,name=...,auth=...,basic-auth=...,tenant-id=...,workers=N,rate-mb=N,rate-burst-mb=N,compression=...,batch-mb=N)",
 			},
 			&cli.StringFlag{
 				Name:  "pyroscope-auth",
-				Usage: "Authentication token for Pyroscope",
+				Usage: "Authentication token for Pyroscope (value, env:NAME or file:PATH)",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-basic-auth",
+				Usage: "Basic auth credentials for Pyroscope in user:password format (value, env:NAME or file:PATH)",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-tenant-id",
+				Usage: "Tenant id sent in X-Scope-OrgID header (value, env:NAME or file:PATH)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "pyroscope-header",
+				Usage: "Extra header for Pyroscope requests in 'Name: value' format, value can be env:NAME or file:PATH",
 			},
 			&cli.DurationFlag{
 				Name:  "pyroscope-timeout",
 				Usage: "Timeout to pyroscope request",
 				Value: PyroscopeTimeout,
 			},
+			&cli.StringFlag{
+				Name:  "pyroscope-tls-cert",
+				Usage: "Client certificate file for mutual TLS with Pyroscope",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-tls-key",
+				Usage: "Client key file for mutual TLS with Pyroscope",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-tls-ca",
+				Usage: "CA bundle file trusted in addition to system CAs",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-tls-server-name",
+				Usage: "Server name used to verify Pyroscope certificate",
+			},
+			&cli.BoolFlag{
+				Name:  "pyroscope-tls-insecure-skip-verify",
+				Usage: "Don't verify Pyroscope certificate (insecure)",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-proxy",
+				Usage: "Proxy URL for Pyroscope requests. Default: HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables",
+			},
+			&cli.IntFlag{
+				Name:  "pyroscope-max-idle-conns",
+				Usage: "Maximum idle connections to Pyroscope. Default: 100",
+			},
+			&cli.IntFlag{
+				Name:  "pyroscope-max-idle-conns-per-host",
+				Usage: "Maximum idle connections per Pyroscope host. Default: 2",
+			},
+			&cli.IntFlag{
+				Name:  "pyroscope-max-conns-per-host",
+				Usage: "Maximum connections per Pyroscope host. Default: 0 (unlimited)",
+			},
+			&cli.DurationFlag{
+				Name:  "pyroscope-idle-conn-timeout",
+				Usage: "How long idle connections to Pyroscope are kept. Default: 90s",
+			},
+			&cli.StringFlag{
+				Name:  "pyroscope-compression",
+				Usage: "Compression of request bodies (none, gzip, zstd), check that your Pyroscope server supports it. Default: none",
+				Value: "none",
+				Action: func(c *cli.Context, name string) error {
+					_, err := pyroscope.ParseCompression(name)
+					return err
+				},
+			},
+			&cli.Float64Flag{
+				Name:  "pyroscope-batch-mb",
+				Usage: "Combine tag groups into pprof requests of up to this size in MB, at most --rate-burst-mb. Default: 0 (disabled)",
+				Action: func(c *cli.Context, size float64) error {
+					if size < 0 {
+						return fmt.Errorf("invalid batch size: %v", size)
+					}
+					return nil
+				},
+			},
 			&cli.IntFlag{
 				Name:  "pyroscope-workers",
 				Usage: "Amount of workers who sends data to pyroscope",
@@ -83,6 +160,48 @@ func main() {
 				Name:  "tag-entrypoint",
 				Usage: "Add entry point to tags",
 			},
+			&cli.BoolFlag{
+				Name:  "tag-request-uri",
+				Usage: "Add request uri without query string to tags",
+			},
+			&cli.BoolFlag{
+				Name:  "tag-request-method",
+				Usage: "Add request method to tags",
+			},
+			&cli.BoolFlag{
+				Name:  "tag-request-query",
+				Usage: "Add request query string to tags",
+			},
+			&cli.BoolFlag{
+				Name:  "tag-request-cookie",
+				Usage: "Add request cookies to tags, they may hold session tokens and have many distinct values",
+			},
+			&cli.BoolFlag{
+				Name:  "tag-request-path",
+				Usage: "Add requested script path to tags",
+			},
+			&cli.BoolFlag{
+				Name:  "inject-profiler-flags",
+				Usage: "Add profiler flags required by dynamic tags, fail on missing flags when disabled. Default: true",
+				Value: true,
+			},
+			&cli.BoolFlag{
+				Name:  "tag-pid",
+				Usage: "Add pid of the sampled process to tags",
+			},
+			&cli.BoolFlag{
+				Name:  "tag-fpm-pool",
+				Usage: "Add php-fpm pool name of the sampled process to tags",
+			},
+			&cli.BoolFlag{
+				Name:  "tag-container",
+				Usage: "Detect hostname, container id and Kubernetes pod, namespace and node and add them to tags",
+			},
+			&cli.StringFlag{
+				Name:  "podinfo-dir",
+				Usage: "Directory with Kubernetes downward API files (pod_name, pod_namespace, node_name)",
+				Value: enrich.DefaultPodInfoDir,
+			},
 			&cli.Float64Flag{
 				Name:  "rate-mb",
 				Usage: "Ingestion rate limit in MB",
@@ -106,13 +225,79 @@ func main() {
 			},
 			&cli.StringSliceFlag{
 				Name:  "entrypoint",
-				Usage: "Limit traces to certain entry points (e.g., index.php)",
+				Usage: "Limit traces to certain entry points (e.g., index.php, !cron/*.php, re:^/app/public/)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "entrypoint-sample",
+				Usage: "Keep one of every N samples of matching entry points (pattern=N, e.g., index.php=10)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "app-route",
+				Usage: "Send matching samples to another Pyroscope app (entrypoint:pattern=app, prefix:path=app or tag:key:glob=app)",
 			},
 			&cli.BoolFlag{
 				Name:  "keep-entrypoint-name",
 				Usage: "Keep entry point name in traces. Default: true",
 				Value: true,
 			},
+			&cli.StringFlag{
+				Name:  "frame-lines",
+				Usage: "Keep file and line of frames in traces (none, frame, leaf). Default: none",
+				Value: "none",
+				Action: func(c *cli.Context, mode string) error {
+					_, err := transform.ParseLineMode(mode)
+					return err
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:  "frame-rule",
+				Usage: "Rewrite or filter frames (kind:pattern or kind:pattern=>replacement, kinds: rename, drop, collapse, truncate-above, truncate-below)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "path-strip-prefix",
+				Usage: "Strip a prefix from entry point and frame paths (e.g., /var/www/current)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "path-rewrite",
+				Usage: "Rewrite entry point and frame paths with a regex (pattern=>replacement)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "path-alias",
+				Usage: "Replace a path prefix with its canonical form, like a symlink (from=to)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "focus",
+				Usage: "Keep only samples with a frame matching the regex (e.g., ^curl_exec$)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "ignore",
+				Usage: "Drop samples with a frame matching the regex",
+			},
+			&cli.IntFlag{
+				Name:  "max-depth",
+				Usage: "Keep only the root-most frames of deeper stacks and mark the cut with a [truncated] frame. Default: 0 (unlimited)",
+				Action: func(c *cli.Context, maxDepth int) error {
+					if maxDepth < 0 {
+						return fmt.Errorf("invalid max depth: %d", maxDepth)
+					}
+					return nil
+				},
+			},
+			&cli.BoolFlag{
+				Name:  "output-fifo",
+				Usage: "Create a named pipe at the phpspy -o path if it doesn't exist, otherwise the file phpspy creates is followed",
+			},
+			&cli.Float64Flag{
+				Name:  "max-line-mb",
+				Usage: "Size limit of a phpspy output line in MB, traces with longer lines are dropped. Raise it for long glopeek values",
+				Value: float64(phpspy.DefaultMaxLineSize) / pyroscope.Megabyte,
+				Action: func(c *cli.Context, size float64) error {
+					if size <= 0 {
+						return fmt.Errorf("invalid max line size: %v", size)
+					}
+					return nil
+				},
+			},
 			&cli.StringFlag{
 				Name:  "instance-name",
 				Usage: "Change the name of this gospy instance (for logging purposes only)",
@@ -4,7 +4,9 @@ go 1.23.3
 
 require (
 	github.com/bmatcuk/doublestar/v4 v4.7.1
+	github.com/google/pprof v0.0.0-20241029153458-d1b30febd7db
 	github.com/hashicorp/golang-lru v1.0.2
+	github.com/klauspost/compress v1.17.11
 	github.com/rs/zerolog v1.33.0
 	github.com/stretchr/testify v1.10.0
 	github.com/urfave/cli/v2 v2.27.5
@@ -3,27 +3,55 @@ package collector
 import (
 	"container/list"
 	"context"
+	"hash/maphash"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/hakastein/gospy/internal/stack"
 )
 
 // For fast counting. We don't expect trace counts to exceed 1 billion
 var countThresholds = []int{10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000}
 
+// ProfileType identifies the kind of values collected for stacks.
+type ProfileType int
+
+const (
+	// ProfileCPU counts how many times each stack was sampled.
+	ProfileCPU ProfileType = iota
+	// ProfileMemory holds the average memory usage in bytes observed for each stack.
+	ProfileMemory
+)
+
 type Sample struct {
-	Time  time.Time
+	Time time.Time
+	// Stack is the stack of the sample in Table, Trace in folded format is used if Table is nil
+	Stack stack.ID
+	Table *stack.Table
 	Trace string
 	Tags  string
+	// App is the Pyroscope application of the sample, the default one is used if it's empty
+	App     string
+	Profile ProfileType
+	// Value is the memory usage in bytes of a ProfileMemory sample,
+	// or the number of samples a down-sampled CPU sample represents, zero counts as one.
+	Value int
 }
 
 // TagCollection represents the Data of traces categorized by Tags over a period of time.
 type TagCollection struct {
-	tags  string
-	data  map[string]int
-	from  time.Time
-	until time.Time
+	tags    string
+	app     string
+	profile ProfileType
+	// data is built from stacks on first use, so stacks are serialized only at upload time
+	data   map[string]int
+	stacks map[stack.ID]int
+	table  *stack.Table
+	from   time.Time
+	until  time.Time
 }
 
 func NewTagCollection(from time.Time, until time.Time, tags string, data map[string]int) *TagCollection {
@@ -35,35 +63,62 @@ func NewTagCollection(from time.Time, until time.Time, tags string, data map[str
 	}
 }
 
+// Len returns the size of the data in folded format, interned stacks aren't folded to measure it.
 func (tc *TagCollection) Len() int {
+	if tc.data == nil && tc.stacks != nil {
+		if len(tc.stacks) == 0 {
+			return 0
+		}
+		size := len(tc.stacks)*2 - 1 // new lines and whitespaces
+		for id, count := range tc.stacks {
+			size += tc.table.FoldedLen(id) + countDigits(count)
+		}
+		return size
+	}
+
 	if len(tc.data) == 0 {
 		return 0
 	}
 	size := len(tc.data)*2 - 1 // new lines and whitespaces
 	for sample, count := range tc.data {
-		size += len(sample)
-		if count == 0 {
-			size += 1
-			continue
-		}
-		// count digits
-		numDigits := 1
-		for _, t := range countThresholds {
-			if count < t {
-				break
-			}
-			numDigits++
-		}
-		size += numDigits
-
+		size += len(sample) + countDigits(count)
 	}
 	return size
 }
 
+// countDigits returns the number of digits of a non-negative count.
+func countDigits(count int) int {
+	numDigits := 1
+	for _, t := range countThresholds {
+		if count < t {
+			break
+		}
+		numDigits++
+	}
+	return numDigits
+}
+
+// Data returns counts of stacks in folded format.
 func (tc *TagCollection) Data() map[string]int {
+	if tc.data == nil && tc.stacks != nil {
+		tc.data = make(map[string]int, len(tc.stacks))
+		for id, count := range tc.stacks {
+			tc.data[tc.table.Folded(id)] = count
+		}
+	}
 	return tc.data
 }
 
+// Stacks returns counts of stacks in the StackTable, it's nil if the collection was created with folded data.
+func (tc *TagCollection) Stacks() map[stack.ID]int {
+	return tc.stacks
+}
+
+// StackTable returns the table of Stacks.
+func (tc *TagCollection) StackTable() *stack.Table {
+	return tc.table
+}
+
 func (tc *TagCollection) From() time.Time {
 	return tc.from
 }
@@ -76,101 +131,373 @@ func (tc *TagCollection) Tags() string {
 	return tc.tags
 }
 
+func (tc *TagCollection) Profile() ProfileType {
+	return tc.profile
+}
+
+// App returns the Pyroscope application of the collection, it's empty for the default one.
+func (tc *TagCollection) App() string {
+	return tc.app
+}
+
+// groupKey identifies a traceGroup, samples of different profile types or applications are never mixed.
+type groupKey struct {
+	app     string
+	profile ProfileType
+	tags    string
+}
+
 // traceGroup represents a collection of stacks with counts and a time range.
 type traceGroup struct {
-	stacks        map[string]int
+	// table is the table stacks are from, it's the current one when the group is created
+	table  *stack.Table
+	stacks map[stack.ID]int
+	// samples counts memory samples per stack to average their values, it's nil for CPU groups
+	samples       map[stack.ID]int
 	from          time.Time
 	until         time.Time
 	queuePosition *list.Element
 }
 
+// queuedGroup is an element of a shard queue, seq orders groups of all shards by creation.
+type queuedGroup struct {
+	key groupKey
+	seq uint64
+}
+
+// shard holds the trace groups of tags hashing to it, queued in creation order.
+type shard struct {
+	mu     sync.Mutex
+	traces map[groupKey]*traceGroup
+	queue  *list.List
+	// frontSeq is the seq of the oldest group, zero if the shard is empty, it's read without the lock
+	frontSeq atomic.Uint64
+}
+
+const (
+	defaultShards        = 16
+	defaultIngestWorkers = 4
+	defaultIngestBuffer  = 10000
+)
+
 // TraceCollector manages trace groups organized by tags and tracks access order.
+// Groups are spread over shards by tag hash, so samples of different tags are added concurrently.
 type TraceCollector struct {
-	mu     sync.RWMutex
-	traces map[string]*traceGroup
-	queue  *list.List
+	shards []*shard
+	// tables store stacks of samples, they're shared with the parser and other collectors
+	tables *stack.Tables
+	seed   maphash.Seed
+	// seq numbers new groups, consumers take the oldest group of all shards
+	seq atomic.Uint64
+	// queued is the number of groups in all shards
+	queued        atomic.Int64
+	ingestWorkers int
+	// ingestBuffer is the size of the channel SubscribeAll passes samples to the collector with
+	ingestBuffer int
+	// ready holds a token while the queue may have tags, it wakes one waiting consumer at a time
+	ready chan struct{}
+}
+
+// Option configures a TraceCollector.
+type Option func(tc *TraceCollector)
+
+// WithShards sets the number of shards, a single shard serializes all samples.
+func WithShards(shards int) Option {
+	return func(tc *TraceCollector) {
+		tc.shards = make([]*shard, max(shards, 1))
+	}
+}
+
+// WithIngestWorkers sets the number of goroutines Subscribe adds samples with.
+func WithIngestWorkers(workers int) Option {
+	return func(tc *TraceCollector) {
+		tc.ingestWorkers = max(workers, 1)
+	}
+}
+
+// WithIngestBuffer sets the number of samples SubscribeAll buffers for the collector, samples are dropped
+// for the collector while the buffer is full.
+func WithIngestBuffer(size int) Option {
+	return func(tc *TraceCollector) {
+		tc.ingestBuffer = max(size, 1)
+	}
+}
+
+// WithStackTables sets the tables stacks of samples are stored in.
+func WithStackTables(tables *stack.Tables) Option {
+	return func(tc *TraceCollector) {
+		tc.tables = tables
+	}
 }
 
 // NewTraceCollector initializes and returns a new TraceCollector.
-func NewTraceCollector() *TraceCollector {
-	return &TraceCollector{
-		traces: make(map[string]*traceGroup),
-		queue:  list.New(),
+func NewTraceCollector(options ...Option) *TraceCollector {
+	tc := &TraceCollector{
+		shards:        make([]*shard, defaultShards),
+		seed:          maphash.MakeSeed(),
+		ingestWorkers: defaultIngestWorkers,
+		ingestBuffer:  defaultIngestBuffer,
+		ready:         make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(tc)
 	}
+	if tc.tables == nil {
+		tc.tables = stack.NewTables(stack.DefaultMaxStacks)
+	}
+	for i := range tc.shards {
+		tc.shards[i] = &shard{
+			traces: make(map[groupKey]*traceGroup),
+			queue:  list.New(),
+		}
+	}
+	return tc
+}
+
+// notify wakes a consumer waiting in ConsumeTagWait, if there is one.
+func (tc *TraceCollector) notify() {
+	select {
+	case tc.ready <- struct{}{}:
+	default:
+	}
+}
+
+// shardOf returns the shard of the group.
+func (tc *TraceCollector) shardOf(key groupKey) *shard {
+	hash := maphash.String(tc.seed, key.tags) ^ maphash.String(tc.seed, key.app)*31 + uint64(key.profile)
+	return tc.shards[hash%uint64(len(tc.shards))]
 }
 
 func (tc *TraceCollector) Len() int {
-	tc.mu.RLock()
-	defer tc.mu.RUnlock()
-	return tc.queue.Len()
+	return int(tc.queued.Load())
 }
 
 // ConsumeTag removes the oldest tag from the traces collection and returns its data.
 // If there are no tags, it returns nil.
 func (tc *TraceCollector) ConsumeTag() (*TagCollection, bool) {
-	tc.mu.Lock()
+	for tc.queued.Load() > 0 {
+		oldest := tc.oldestShard()
+		if oldest == nil {
+			break
+		}
+
+		// the group may be taken by another consumer since oldestShard, the next oldest one of the shard is taken then
+		key, tg, remaining, ok := tc.pop(oldest)
+		if !ok {
+			continue
+		}
+
+		// pass the wakeup on to the next consumer
+		if remaining > 0 {
+			tc.notify()
+		}
+
+		for stackID, count := range tg.samples {
+			tg.stacks[stackID] /= count
+		}
+
+		collection := &TagCollection{
+			from:    tg.from,
+			until:   tg.until,
+			tags:    key.tags,
+			app:     key.app,
+			profile: key.profile,
+			stacks:  tg.stacks,
+			table:   tg.table,
+		}
+
+		return collection, true
+	}
+	return nil, false
+}
+
+// oldestShard returns the shard with the oldest group, or nil if all shards are empty.
+func (tc *TraceCollector) oldestShard() *shard {
+	var oldest *shard
+	var oldestSeq uint64
+	for _, sh := range tc.shards {
+		if seq := sh.frontSeq.Load(); seq != 0 && (oldest == nil || seq < oldestSeq) {
+			oldest, oldestSeq = sh, seq
+		}
+	}
+	return oldest
+}
+
+// pop removes the oldest group of the shard and returns the number of groups left in all shards.
+// The queued counter is changed under the shard lock like in AddSample, so it never goes negative.
+func (tc *TraceCollector) pop(sh *shard) (groupKey, *traceGroup, int64, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	elem := tc.queue.Front()
+	elem := sh.queue.Front()
 	if elem == nil {
-		return nil, false
+		return groupKey{}, nil, 0, false
 	}
 
-	tags := elem.Value.(string)
-	tg := tc.traces[tags]
+	key := elem.Value.(queuedGroup).key
+	tg := sh.traces[key]
 
-	tc.queue.Remove(elem)
-	delete(tc.traces, tags)
+	sh.queue.Remove(elem)
+	delete(sh.traces, key)
+	sh.updateFrontSeq()
 
-	tc.mu.Unlock()
+	return key, tg, tc.queued.Add(-1), true
+}
 
-	return NewTagCollection(
-		tg.from,
-		tg.until,
-		tags,
-		tg.stacks,
-	), true
+// updateFrontSeq sets frontSeq after the queue front has changed, the shard must be locked.
+func (sh *shard) updateFrontSeq() {
+	if front := sh.queue.Front(); front != nil {
+		sh.frontSeq.Store(front.Value.(queuedGroup).seq)
+		return
+	}
+	sh.frontSeq.Store(0)
+}
+
+// ConsumeTagWait is like ConsumeTag, but waits for a tag if there are none.
+// It returns false once the context is done.
+func (tc *TraceCollector) ConsumeTagWait(ctx context.Context) (*TagCollection, bool) {
+	for ctx.Err() == nil {
+		if collection, ok := tc.ConsumeTag(); ok {
+			return collection, true
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-tc.ready:
+		}
+	}
+	return nil, false
 }
 
 // AddSample increments the sample count in a traceGroup for a given stack and updates access order.
-func (tc *TraceCollector) AddSample(stack *Sample) {
-	tc.mu.Lock()
-	defer tc.mu.Unlock()
+// Memory samples add their value instead, it's averaged when the group is consumed.
+func (tc *TraceCollector) AddSample(sample *Sample) {
+	table := tc.tables.Current()
+	stackID := sample.Stack
+	switch {
+	case sample.Table == nil:
+		stackID = table.InternFolded(sample.Trace)
+	case sample.Table != table:
+		stackID = table.Intern(sample.Table.Frames(sample.Stack))
+	}
+
+	key := groupKey{app: sample.App, profile: sample.Profile, tags: sample.Tags}
+	sh := tc.shardOf(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	tg, exists := tc.traces[stack.Tags]
+	tg, exists := sh.traces[key]
 	if !exists {
 		tg = &traceGroup{
-			stacks: make(map[string]int),
-			from:   stack.Time,
-			until:  stack.Time,
+			table:  table,
+			stacks: make(map[stack.ID]int),
+			from:   sample.Time,
+			until:  sample.Time,
 		}
-		tc.traces[stack.Tags] = tg
+		if sample.Profile == ProfileMemory {
+			tg.samples = make(map[stack.ID]int)
+		}
+		sh.traces[key] = tg
+		// the group is counted before it's pushed, so it can't be consumed before it's counted
+		tc.queued.Add(1)
 		// Push tag into end of the queue
-		tg.queuePosition = tc.queue.PushBack(stack.Tags)
+		tg.queuePosition = sh.queue.PushBack(queuedGroup{key: key, seq: tc.seq.Add(1)})
+		if sh.queue.Len() == 1 {
+			sh.updateFrontSeq()
+		}
+		tc.notify()
+	}
+
+	// the table was replaced since the group was created, all stacks of a group are from its table
+	if tg.table != table {
+		stackID = tg.table.Intern(table.Frames(stackID))
 	}
 
-	if stack.Time.After(tg.until) {
-		tg.until = stack.Time
+	if sample.Time.After(tg.until) {
+		tg.until = sample.Time
+	}
+	if sample.Time.Before(tg.from) {
+		tg.from = sample.Time
 	}
-	if stack.Time.Before(tg.from) {
-		tg.from = stack.Time
+	if tg.samples != nil {
+		tg.stacks[stackID] += sample.Value
+		tg.samples[stackID]++
+		return
 	}
-	tg.stacks[stack.Trace]++
+	tg.stacks[stackID] += max(sample.Value, 1)
 }
 
-// Subscribe starts a goroutine that listens to stacksChannel and adds samples to the TraceCollector.
+// Subscribe starts ingest goroutines that listen to stacksChannel and add samples to the TraceCollector.
 func (tc *TraceCollector) Subscribe(ctx context.Context, stacksChannel <-chan *Sample) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("shutdown subscriber")
+	for i := 0; i < tc.ingestWorkers; i++ {
+		go tc.subscribe(ctx, stacksChannel)
+	}
+}
+
+// subscribe adds samples from stacksChannel until it's closed or the context is done.
+func (tc *TraceCollector) subscribe(ctx context.Context, stacksChannel <-chan *Sample) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("shutdown subscriber")
+			return
+		case sample, ok := <-stacksChannel:
+			if !ok {
 				return
-			case sample, ok := <-stacksChannel:
-				if !ok {
-					return
-				}
-				tc.AddSample(sample)
 			}
+			tc.AddSample(sample)
+		}
+	}
+}
+
+// SubscribeAll fans samples from stacksChannel out to the collectors. Every collector has its own buffered channel
+// consumed by its ingest goroutines, so a collector that is slow to add samples doesn't hold back the others.
+// Samples are dropped for a collector while its buffer is full.
+func SubscribeAll(ctx context.Context, stacksChannel <-chan *Sample, collectors ...*TraceCollector) {
+	channels := make([]chan *Sample, len(collectors))
+	for i, tc := range collectors {
+		channels[i] = make(chan *Sample, tc.ingestBuffer)
+		tc.Subscribe(ctx, channels[i])
+	}
+	go fanOut(ctx, stacksChannel, channels)
+}
+
+// fanOut sends samples from stacksChannel to every channel until it's closed or the context is done.
+func fanOut(ctx context.Context, stacksChannel <-chan *Sample, channels []chan *Sample) {
+	defer func() {
+		for _, channel := range channels {
+			close(channel)
 		}
 	}()
+
+	dropped := make([]int, len(channels))
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("shutdown subscriber")
+			return
+		case sample, ok := <-stacksChannel:
+			if !ok {
+				return
+			}
+			for i, channel := range channels {
+				select {
+				case channel <- sample:
+					if dropped[i] > 0 {
+						log.Warn().
+							Int("collector", i).
+							Int("dropped_samples", dropped[i]).
+							Msg("collector caught up, samples were dropped while its buffer was full")
+						dropped[i] = 0
+					}
+				default:
+					if dropped[i] == 0 {
+						log.Warn().Int("collector", i).Msg("collector buffer is full, dropping samples")
+					}
+					dropped[i]++
+				}
+			}
+		}
+	}
 }
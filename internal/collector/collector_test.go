@@ -3,6 +3,9 @@ package collector_test
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/stack"
 )
 
 func newTestCollector() *collector.TraceCollector {
@@ -97,14 +101,14 @@ func TestTraceCollector(t *testing.T) {
 		baseTime := time.Now().Truncate(time.Millisecond)
 
 		addSamples(c, []collector.Sample{
-			{baseTime, "main;login", "auth"},
-			{baseTime.Add(20 * time.Millisecond), "http;handler", "api"},
+			{Time: baseTime, Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(20 * time.Millisecond), Trace: "http;handler", Tags: "api"},
 		})
 
 		assert.Equal(t, 2, c.Len())
 
 		addSamples(c, []collector.Sample{
-			{baseTime, "main;login", "auth"},
+			{Time: baseTime, Trace: "main;login", Tags: "auth"},
 		})
 
 		assert.Equal(t, 2, c.Len())
@@ -115,17 +119,17 @@ func TestTraceCollector(t *testing.T) {
 		baseTime := time.Now().Truncate(time.Millisecond)
 
 		addSamples(c, []collector.Sample{
-			{baseTime, "main;login", "auth"},
-			{baseTime.Add(20 * time.Millisecond), "http;handler", "api"},
-			{baseTime.Add(10 * time.Millisecond), "main;login", "auth"},
-			{baseTime.Add(10 * time.Millisecond), "http;handler", "web"},
+			{Time: baseTime, Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(20 * time.Millisecond), Trace: "http;handler", Tags: "api"},
+			{Time: baseTime.Add(10 * time.Millisecond), Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(10 * time.Millisecond), Trace: "http;handler", Tags: "web"},
 		})
 
 		verifyOrder(t, c, []string{"auth", "api"})
 
 		addSamples(c, []collector.Sample{
-			{baseTime.Add(20 * time.Millisecond), "main;login", "auth"},
-			{baseTime.Add(20 * time.Millisecond), "http;handler", "api"},
+			{Time: baseTime.Add(20 * time.Millisecond), Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(20 * time.Millisecond), Trace: "http;handler", Tags: "api"},
 		})
 
 		verifyOrder(t, c, []string{"web", "auth", "api"})
@@ -136,9 +140,9 @@ func TestTraceCollector(t *testing.T) {
 		baseTime := time.Now().Truncate(time.Millisecond)
 
 		addSamples(c, []collector.Sample{
-			{baseTime, "main;login", "auth"},
-			{baseTime.Add(10 * time.Millisecond), "main;login", "auth"},
-			{baseTime.Add(20 * time.Millisecond), "http;handler", "api"},
+			{Time: baseTime, Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(10 * time.Millisecond), Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(20 * time.Millisecond), Trace: "http;handler", Tags: "api"},
 		})
 
 		verifyState(t, c, map[string]collectorData{
@@ -150,9 +154,9 @@ func TestTraceCollector(t *testing.T) {
 		})
 
 		addSamples(c, []collector.Sample{
-			{baseTime.Add(30 * time.Millisecond), "main;logout", "auth"},
-			{baseTime.Add(10 * time.Millisecond), "main;login", "auth"},
-			{baseTime.Add(40 * time.Millisecond), "http;handler", "api"},
+			{Time: baseTime.Add(30 * time.Millisecond), Trace: "main;logout", Tags: "auth"},
+			{Time: baseTime.Add(10 * time.Millisecond), Trace: "main;login", Tags: "auth"},
+			{Time: baseTime.Add(40 * time.Millisecond), Trace: "http;handler", Tags: "api"},
 		})
 
 		verifyState(t, c, map[string]collectorData{
@@ -170,6 +174,137 @@ func TestTraceCollector(t *testing.T) {
 	})
 }
 
+func TestTraceCollector_WeightedSamples(t *testing.T) {
+	c := newTestCollector()
+	baseTime := time.Now().Truncate(time.Millisecond)
+
+	addSamples(c, []collector.Sample{
+		{Time: baseTime, Trace: "main;login", Tags: "auth"},
+		{Time: baseTime, Trace: "main;login", Tags: "auth", Value: 10},
+		{Time: baseTime, Trace: "main;logout", Tags: "auth", Value: 1},
+	})
+
+	verifyState(t, c, map[string]collectorData{
+		"auth": {
+			data:  map[string]int{"main;login": 11, "main;logout": 1},
+			from:  baseTime,
+			until: baseTime,
+		},
+	})
+}
+
+func TestTraceCollector_MemoryProfile(t *testing.T) {
+	c := newTestCollector()
+	baseTime := time.Now().Truncate(time.Millisecond)
+
+	addSamples(c, []collector.Sample{
+		{Time: baseTime, Trace: "main;login", Tags: "auth"},
+		{Time: baseTime, Trace: "main;login", Tags: "auth", Profile: collector.ProfileMemory, Value: 1000},
+		{Time: baseTime.Add(10 * time.Millisecond), Trace: "main;login", Tags: "auth", Profile: collector.ProfileMemory, Value: 3000},
+		{Time: baseTime.Add(10 * time.Millisecond), Trace: "main;logout", Tags: "auth", Profile: collector.ProfileMemory, Value: 512},
+		{Time: baseTime.Add(10 * time.Millisecond), Trace: "main;login", Tags: "auth"},
+	})
+
+	assert.Equal(t, 2, c.Len())
+
+	cpu, ok := c.ConsumeTag()
+	require.True(t, ok)
+	assert.Equal(t, collector.ProfileCPU, cpu.Profile())
+	assert.Equal(t, map[string]int{"main;login": 2}, cpu.Data())
+
+	memory, ok := c.ConsumeTag()
+	require.True(t, ok)
+	assert.Equal(t, collector.ProfileMemory, memory.Profile())
+	assert.Equal(t, "auth", memory.Tags())
+	assert.Equal(t, map[string]int{"main;login": 2000, "main;logout": 512}, memory.Data())
+	assert.Equal(t, baseTime, memory.From())
+	assert.Equal(t, baseTime.Add(10*time.Millisecond), memory.Until())
+}
+
+func TestTraceCollector_RoutedApps(t *testing.T) {
+	c := newTestCollector()
+	baseTime := time.Now().Truncate(time.Millisecond)
+
+	addSamples(c, []collector.Sample{
+		{Time: baseTime, Trace: "main;login", Tags: "auth"},
+		{Time: baseTime, Trace: "main;login", Tags: "auth", App: "tenant1"},
+		{Time: baseTime, Trace: "main;logout", Tags: "auth", App: "tenant1"},
+	})
+
+	assert.Equal(t, 2, c.Len(), "Samples of different apps mustn't be grouped together")
+
+	defaultApp, ok := c.ConsumeTag()
+	require.True(t, ok)
+	assert.Equal(t, "", defaultApp.App())
+	assert.Equal(t, map[string]int{"main;login": 1}, defaultApp.Data())
+
+	routed, ok := c.ConsumeTag()
+	require.True(t, ok)
+	assert.Equal(t, "tenant1", routed.App())
+	assert.Equal(t, "auth", routed.Tags())
+	assert.Equal(t, map[string]int{"main;login": 1, "main;logout": 1}, routed.Data())
+}
+
+func TestTraceCollector_StackTable(t *testing.T) {
+	tables := stack.NewTables(stack.DefaultMaxStacks)
+	c := collector.NewTraceCollector(collector.WithStackTables(tables))
+	baseTime := time.Now().Truncate(time.Millisecond)
+
+	table := tables.Current()
+	login := table.Intern([]string{"main", "login"})
+	addSamples(c, []collector.Sample{
+		{Time: baseTime, Stack: login, Table: table, Tags: "auth"},
+		{Time: baseTime, Trace: "main;login", Tags: "auth"},
+		{Time: baseTime, Trace: "main;logout", Tags: "auth", Value: 10},
+	})
+
+	collection, ok := c.ConsumeTag()
+	require.True(t, ok)
+	assert.Same(t, table, collection.StackTable())
+	assert.Equal(t, map[stack.ID]int{login: 2, table.InternFolded("main;logout"): 10}, collection.Stacks(),
+		"Interned and folded samples of the same stack must be counted together")
+
+	size := collection.Len()
+	assert.Equal(t, map[string]int{"main;login": 2, "main;logout": 10}, collection.Data())
+	assert.Equal(t, len("main;login 2\nmain;logout 10"), size, "Len must be the folded size")
+	assert.Equal(t, size, collection.Len())
+}
+
+// TestTraceCollector_StackTablesReplaced tests that stacks stay bounded across consume cycles
+// when the profiled code keeps producing new stacks, and that stacks of replaced tables are kept.
+func TestTraceCollector_StackTablesReplaced(t *testing.T) {
+	const (
+		maxStacks       = 1000
+		stacksPerCycle  = 300
+		cycles          = 50
+		stacksPerSample = 2 // leaf and its parent
+	)
+	tables := stack.NewTables(maxStacks)
+	c := collector.NewTraceCollector(collector.WithStackTables(tables), collector.WithShards(1))
+	baseTime := time.Now().Truncate(time.Millisecond)
+
+	collectionTables := make(map[*stack.Table]struct{})
+	for cycle := 0; cycle < cycles; cycle++ {
+		want := make(map[string]int, stacksPerCycle)
+		for i := 0; i < stacksPerCycle; i++ {
+			frames := []string{fmt.Sprintf("cycle%d", cycle), fmt.Sprintf("func%d", i)}
+			// samples are interned by the parser in the current table, the group may have an older one
+			table := tables.Current()
+			c.AddSample(&collector.Sample{Time: baseTime, Stack: table.Intern(frames), Table: table, Tags: "app"})
+			want[strings.Join(frames, ";")] = 1
+		}
+
+		collection, ok := c.ConsumeTag()
+		require.True(t, ok)
+		assert.Equal(t, want, collection.Data(), "cycle %d", cycle)
+		collectionTables[collection.StackTable()] = struct{}{}
+
+		assert.LessOrEqual(t, tables.Current().Len(), maxStacks+stacksPerCycle*stacksPerSample,
+			"the current table must be bounded, cycle %d", cycle)
+	}
+	assert.Greater(t, len(collectionTables), 1, "full tables must be replaced")
+}
+
 func TestTraceCollector_Subscribe(t *testing.T) {
 	t.Run("SimpleWrite", func(t *testing.T) {
 		ctx := context.Background()
@@ -208,6 +343,171 @@ func TestTraceCollector_Subscribe(t *testing.T) {
 	})
 }
 
+func TestTraceCollector_ConsumeTagEmpty(t *testing.T) {
+	c := newTestCollector()
+
+	_, ok := c.ConsumeTag()
+	assert.False(t, ok)
+
+	// ConsumeTag on an empty queue must release the lock
+	c.AddSample(&collector.Sample{Time: time.Now(), Trace: "main", Tags: "tag1"})
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestTraceCollector_ConsumeTagWait(t *testing.T) {
+	t.Run("WakesOnNewTag", func(t *testing.T) {
+		c := newTestCollector()
+		consumed := make(chan *collector.TagCollection)
+		go func() {
+			collection, _ := c.ConsumeTagWait(context.Background())
+			consumed <- collection
+		}()
+
+		select {
+		case <-consumed:
+			t.Fatal("ConsumeTagWait must wait for a tag")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		c.AddSample(&collector.Sample{Time: time.Now(), Trace: "main", Tags: "tag1"})
+
+		select {
+		case collection := <-consumed:
+			assert.Equal(t, "tag1", collection.Tags())
+		case <-time.After(time.Second):
+			t.Fatal("ConsumeTagWait must wake up on a new tag")
+		}
+	})
+
+	t.Run("WakesAllConsumers", func(t *testing.T) {
+		c := newTestCollector()
+		consumed := make(chan string, 3)
+		for i := 0; i < 3; i++ {
+			go func() {
+				collection, _ := c.ConsumeTagWait(context.Background())
+				consumed <- collection.Tags()
+			}()
+		}
+		// let consumers start waiting
+		<-time.After(50 * time.Millisecond)
+
+		addSamples(c, []collector.Sample{
+			{Time: time.Now(), Trace: "main", Tags: "tag1"},
+			{Time: time.Now(), Trace: "main", Tags: "tag2"},
+			{Time: time.Now(), Trace: "main", Tags: "tag3"},
+		})
+
+		var tags []string
+		for i := 0; i < 3; i++ {
+			select {
+			case tag := <-consumed:
+				tags = append(tags, tag)
+			case <-time.After(time.Second):
+				t.Fatal("every waiting consumer must get a tag")
+			}
+		}
+		assert.ElementsMatch(t, []string{"tag1", "tag2", "tag3"}, tags)
+	})
+
+	t.Run("ContextCancellation", func(t *testing.T) {
+		c := newTestCollector()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan bool)
+		go func() {
+			_, ok := c.ConsumeTagWait(ctx)
+			done <- ok
+		}()
+
+		cancel()
+
+		select {
+		case ok := <-done:
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("ConsumeTagWait must return once the context is done")
+		}
+	})
+}
+
+func TestTraceCollector_Shards(t *testing.T) {
+	t.Run("ConsumesOldestTagOfAllShards", func(t *testing.T) {
+		c := collector.NewTraceCollector(collector.WithShards(8))
+		var samples []collector.Sample
+		for i := 0; i < 50; i++ {
+			samples = append(samples, collector.Sample{Time: time.Now(), Trace: "main", Tags: fmt.Sprintf("tag%d", i)})
+		}
+		addSamples(c, samples)
+
+		for i := 0; i < 50; i++ {
+			collection, ok := c.ConsumeTag()
+			require.True(t, ok)
+			assert.Equal(t, fmt.Sprintf("tag%d", i), collection.Tags(), "Tags must be consumed in creation order")
+		}
+		assert.Equal(t, 0, c.Len())
+	})
+
+	t.Run("ConcurrentAddAndConsume", func(t *testing.T) {
+		c := collector.NewTraceCollector(collector.WithShards(4))
+		const producers, samplesPerProducer = 4, 1000
+
+		var wg sync.WaitGroup
+		for p := 0; p < producers; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < samplesPerProducer; i++ {
+					c.AddSample(&collector.Sample{Time: time.Now(), Trace: "main", Tags: fmt.Sprintf("tag%d", i%37)})
+				}
+			}()
+		}
+
+		total := 0
+		consume := func() {
+			for {
+				collection, ok := c.ConsumeTag()
+				if !ok {
+					return
+				}
+				total += collection.Data()["main"]
+				require.GreaterOrEqual(t, c.Len(), 0, "Queue size must never be negative")
+			}
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		for {
+			select {
+			case <-done:
+				consume()
+				assert.Equal(t, producers*samplesPerProducer, total, "Every sample must be consumed once")
+				return
+			default:
+				consume()
+			}
+		}
+	})
+}
+
+func TestSubscribeAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	samplesChan := make(chan *collector.Sample)
+	first, second := newTestCollector(), newTestCollector()
+	collector.SubscribeAll(ctx, samplesChan, first, second)
+
+	samplesChan <- &collector.Sample{
+		Tags:  "tag1",
+		Trace: "trace1",
+		Time:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.Eventually(t, func() bool {
+		return first.Len() == 1 && second.Len() == 1
+	}, time.Second, 10*time.Millisecond, "Every collector must receive the sample")
+}
+
 func TestTagCollection(t *testing.T) {
 	t.Run("Getters", func(t *testing.T) {
 		now := time.Now()
@@ -314,3 +614,39 @@ func BenchmarkTraceCollector_ConsumeTag(b *testing.B) {
 		}
 	})
 }
+
+// benchmarkParallelAddSample adds samples of 64 tags from parallel goroutines, like ingest workers do.
+func benchmarkParallelAddSample(b *testing.B, options ...collector.Option) {
+	tc := collector.NewTraceCollector(options...)
+	tags := make([]string, 64)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("tag%d", i)
+	}
+	now := time.Now()
+	var goroutines atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		// goroutines start at different tags, like samples of different requests
+		i := int(goroutines.Add(1)) * 7
+		for pb.Next() {
+			tc.AddSample(&collector.Sample{
+				Time:  now,
+				Trace: "main;func",
+				Tags:  tags[i%len(tags)],
+			})
+			i++
+		}
+	})
+}
+
+func BenchmarkTraceCollector_ParallelAddSample(b *testing.B) {
+	b.Run("SingleShard", func(b *testing.B) {
+		benchmarkParallelAddSample(b, collector.WithShards(1))
+	})
+	b.Run("Sharded", func(b *testing.B) {
+		benchmarkParallelAddSample(b)
+	})
+}
@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeAll_SlowCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	samplesChan := make(chan *Sample)
+	slow := NewTraceCollector(WithShards(1), WithIngestBuffer(1))
+	fast := NewTraceCollector()
+	SubscribeAll(ctx, samplesChan, slow, fast)
+
+	// the slow collector can't add samples until its shard is unlocked
+	slow.shards[0].mu.Lock()
+	const sampleCount = 10
+	for i := 0; i < sampleCount; i++ {
+		select {
+		case samplesChan <- &Sample{Tags: fmt.Sprintf("tag%d", i), Trace: "main", Time: time.Now()}:
+		case <-time.After(time.Second):
+			t.Fatal("a slow collector must not block sending samples")
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return fast.Len() == sampleCount
+	}, time.Second, 10*time.Millisecond, "the fast collector must receive every sample")
+
+	slow.shards[0].mu.Unlock()
+	assert.Eventually(t, func() bool {
+		return slow.Len() > 0
+	}, time.Second, 10*time.Millisecond, "the slow collector must receive samples once it catches up")
+	assert.Less(t, slow.Len(), sampleCount, "samples must be dropped while the slow collector's buffer is full")
+}
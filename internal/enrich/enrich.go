@@ -0,0 +1,179 @@
+package enrich
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultProcPath       = "/proc"
+	DefaultPodInfoDir     = "/etc/podinfo"
+	DefaultNamespaceFile  = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	ContainerIDTag        = "container_id"
+	PodTag                = "pod"
+	NamespaceTag          = "namespace"
+	NodeTag               = "node"
+	HostnameTag           = "hostname"
+	containerIDTrimLength = 12
+)
+
+// containerIDRegexp matches container ids written by docker, containerd and cri-o into cgroup paths.
+// Short ids (first 12 symbols) are used as tag values, same as docker does.
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// k8sSource describes where a Kubernetes value can be found: downward API env variable or file.
+type k8sSource struct {
+	tag    string
+	envVar string
+	file   string
+}
+
+var k8sSources = []k8sSource{
+	{tag: PodTag, envVar: "POD_NAME", file: "pod_name"},
+	{tag: NamespaceTag, envVar: "POD_NAMESPACE", file: "pod_namespace"},
+	{tag: NodeTag, envVar: "NODE_NAME", file: "node_name"},
+}
+
+// Enricher detects container, Kubernetes and host metadata to be used as static tags.
+type Enricher struct {
+	ProcPath      string
+	PodInfoDir    string
+	NamespaceFile string
+	Getenv        func(string) string
+	Hostname      func() (string, error)
+	// SkipContainerID disables container id detection, e.g. when it's resolved for each sample instead.
+	SkipContainerID bool
+}
+
+// New creates an Enricher reading from the default system locations.
+func New() *Enricher {
+	return &Enricher{
+		ProcPath:      DefaultProcPath,
+		PodInfoDir:    DefaultPodInfoDir,
+		NamespaceFile: DefaultNamespaceFile,
+		Getenv:        os.Getenv,
+		Hostname:      os.Hostname,
+	}
+}
+
+// Detect returns detected metadata for the process with the given pid.
+// Pid 0 means gospy's own process. Values which can't be detected are omitted.
+func (e *Enricher) Detect(pid int) map[string]string {
+	detected := make(map[string]string)
+
+	if hostname, err := e.Hostname(); err == nil && hostname != "" {
+		detected[HostnameTag] = hostname
+	}
+
+	if !e.SkipContainerID {
+		if containerID := e.containerID(pid); containerID != "" {
+			detected[ContainerIDTag] = containerID
+		}
+	}
+
+	for _, source := range k8sSources {
+		if value := e.k8sValue(source); value != "" {
+			detected[source.tag] = value
+		}
+	}
+
+	if _, ok := detected[NamespaceTag]; !ok {
+		if value := readFileValue(e.NamespaceFile); value != "" {
+			detected[NamespaceTag] = value
+		}
+	}
+
+	return detected
+}
+
+// Apply adds detected metadata to tags in key=value format.
+// Tags set explicitly by user take precedence over the detected ones.
+func (e *Enricher) Apply(tags []string, pid int) []string {
+	userKeys := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if idx := strings.Index(tag, "="); idx != -1 {
+			userKeys[tag[:idx]] = true
+		}
+	}
+
+	detected := e.Detect(pid)
+	keys := make([]string, 0, len(detected))
+	for key := range detected {
+		if !userKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := make([]string, 0, len(tags)+len(keys))
+	result = append(result, tags...)
+	for _, key := range keys {
+		result = append(result, key+"="+sanitizeValue(detected[key]))
+	}
+
+	return result
+}
+
+// containerID extracts container id from the cgroup file of the process.
+func (e *Enricher) containerID(pid int) string {
+	process := "self"
+	if pid > 0 {
+		process = strconv.Itoa(pid)
+	}
+	return ContainerID(e.ProcPath, process)
+}
+
+// ContainerID returns the short container id of the process read from its cgroup file in procPath (usually /proc).
+// The process is a pid or `self`. It returns an empty string if the process doesn't run in a container.
+func ContainerID(procPath, process string) string {
+	file, err := os.Open(filepath.Join(procPath, process, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	return ParseContainerID(bufio.NewScanner(file))
+}
+
+func (e *Enricher) k8sValue(source k8sSource) string {
+	if value := strings.TrimSpace(e.Getenv(source.envVar)); value != "" {
+		return value
+	}
+	if e.PodInfoDir == "" {
+		return ""
+	}
+	return readFileValue(filepath.Join(e.PodInfoDir, source.file))
+}
+
+// ParseContainerID returns the first container id found in cgroup lines.
+// Supported formats are cgroup v1 (12:memory:/docker/<id>) and v2 (0::/system.slice/docker-<id>.scope).
+func ParseContainerID(scanner *bufio.Scanner) string {
+	for scanner.Scan() {
+		line := scanner.Text()
+		if containerID := containerIDRegexp.FindString(line); containerID != "" {
+			return containerID[:containerIDTrimLength]
+		}
+	}
+	return ""
+}
+
+func readFileValue(path string) string {
+	if path == "" {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// sanitizeValue makes detected value safe to be used as a static tag value.
+func sanitizeValue(value string) string {
+	return strings.ReplaceAll(value, ",", "͵")
+}
@@ -0,0 +1,146 @@
+package enrich_test
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/enrich"
+)
+
+const containerID = "3f4e5d6c7b8a99887766554433221100ffeeddccbbaa99887766554433221100"
+
+func newTestEnricher(t *testing.T, env map[string]string) *enrich.Enricher {
+	t.Helper()
+	root := t.TempDir()
+
+	return &enrich.Enricher{
+		ProcPath:      filepath.Join(root, "proc"),
+		PodInfoDir:    filepath.Join(root, "podinfo"),
+		NamespaceFile: filepath.Join(root, "namespace"),
+		Getenv: func(key string) string {
+			return env[key]
+		},
+		Hostname: func() (string, error) {
+			return "web-1", nil
+		},
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestParseContainerID(t *testing.T) {
+	tests := []struct {
+		name     string
+		cgroup   string
+		expected string
+	}{
+		{
+			name:     "cgroup v1 docker",
+			cgroup:   "12:memory:/docker/" + containerID + "\n11:cpu:/docker/" + containerID,
+			expected: containerID[:12],
+		},
+		{
+			name:     "cgroup v2 systemd scope",
+			cgroup:   "0::/system.slice/docker-" + containerID + ".scope",
+			expected: containerID[:12],
+		},
+		{
+			name:     "kubernetes containerd",
+			cgroup:   "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1a2b_3c4d.slice/cri-containerd-" + containerID + ".scope",
+			expected: containerID[:12],
+		},
+		{
+			name:     "host process",
+			cgroup:   "0::/user.slice/user-1000.slice/session-2.scope",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(tt.cgroup))
+			assert.Equal(t, tt.expected, enrich.ParseContainerID(scanner))
+		})
+	}
+}
+
+func TestEnricher_Detect(t *testing.T) {
+	t.Run("environment variables", func(t *testing.T) {
+		e := newTestEnricher(t, map[string]string{
+			"POD_NAME":      "web-5d8f",
+			"POD_NAMESPACE": "shop",
+			"NODE_NAME":     "node-3",
+		})
+		writeFile(t, filepath.Join(e.ProcPath, "self", "cgroup"), "0::/docker/"+containerID)
+
+		assert.Equal(t, map[string]string{
+			"hostname":     "web-1",
+			"container_id": containerID[:12],
+			"pod":          "web-5d8f",
+			"namespace":    "shop",
+			"node":         "node-3",
+		}, e.Detect(0))
+	})
+
+	t.Run("downward api files and target pid", func(t *testing.T) {
+		e := newTestEnricher(t, nil)
+		writeFile(t, filepath.Join(e.ProcPath, "42", "cgroup"), "0::/docker/"+containerID)
+		writeFile(t, filepath.Join(e.PodInfoDir, "pod_name"), "web-5d8f\n")
+		writeFile(t, filepath.Join(e.PodInfoDir, "node_name"), "node-3")
+		writeFile(t, e.NamespaceFile, "shop")
+
+		assert.Equal(t, map[string]string{
+			"hostname":     "web-1",
+			"container_id": containerID[:12],
+			"pod":          "web-5d8f",
+			"namespace":    "shop",
+			"node":         "node-3",
+		}, e.Detect(42))
+	})
+
+	t.Run("container id skipped", func(t *testing.T) {
+		e := newTestEnricher(t, nil)
+		e.SkipContainerID = true
+		writeFile(t, filepath.Join(e.ProcPath, "self", "cgroup"), "0::/docker/"+containerID)
+
+		assert.Equal(t, map[string]string{"hostname": "web-1"}, e.Detect(0))
+	})
+
+	t.Run("nothing detected", func(t *testing.T) {
+		e := newTestEnricher(t, nil)
+		e.Hostname = func() (string, error) {
+			return "", errors.New("no hostname")
+		}
+
+		assert.Empty(t, e.Detect(0))
+	})
+}
+
+func TestContainerID(t *testing.T) {
+	procPath := t.TempDir()
+	writeFile(t, filepath.Join(procPath, "42", "cgroup"), "12:memory:/docker/"+containerID)
+
+	assert.Equal(t, containerID[:12], enrich.ContainerID(procPath, "42"))
+	assert.Empty(t, enrich.ContainerID(procPath, "43"))
+}
+
+func TestEnricher_Apply(t *testing.T) {
+	e := newTestEnricher(t, map[string]string{
+		"POD_NAMESPACE": "shop,eu",
+	})
+
+	tags := e.Apply([]string{"env=prod", "hostname=frontend"}, 0)
+
+	assert.Equal(t, []string{"env=prod", "hostname=frontend", "namespace=shop͵eu"}, tags)
+}
@@ -6,23 +6,35 @@ import (
 	"fmt"
 	"github.com/hakastein/gospy/internal/collector"
 	"github.com/hakastein/gospy/internal/phpspy"
+	"github.com/hakastein/gospy/internal/profiler"
 	"github.com/hakastein/gospy/internal/tag"
 )
 
+// Init creates the parser of the profiler output. It's configured by the profiler options after
+// Profiler.EnsureMetaSources, so the parser and the profiler agree on the output format.
 func Init(
-	profiler string,
+	profilerInstance profiler.Profiler,
 	entryPoints []string,
 	tagsMapping map[string][]tag.DynamicTag,
 	tagEntrypoint bool,
 	keepEntrypointName bool,
+	options ...phpspy.Option,
 ) (Parser, error) {
 	var parser Parser
 
-	switch profiler {
-	case "phpspy":
-		parser = phpspy.NewParser(entryPoints, tagsMapping, tagEntrypoint, keepEntrypointName)
+	switch profilerInstance := profilerInstance.(type) {
+	case *phpspy.Profiler:
+		phpspyOptions := profilerInstance.Options()
+		if phpspyOptions.MemoryUsage {
+			options = append(options, phpspy.WithMemoryProfile())
+		}
+		if phpspyOptions.SingleLine {
+			parser = phpspy.NewSingleLineParser(entryPoints, tagsMapping, tagEntrypoint, keepEntrypointName, options...)
+		} else {
+			parser = phpspy.NewParser(entryPoints, tagsMapping, tagEntrypoint, keepEntrypointName, options...)
+		}
 	default:
-		return nil, fmt.Errorf("unknown profiler: %s", profiler)
+		return nil, fmt.Errorf("unknown profiler: %T", profilerInstance)
 	}
 
 	return parser, nil
@@ -0,0 +1,30 @@
+package phpspy
+
+import (
+	"github.com/hakastein/gospy/internal/enrich"
+)
+
+const containerResolverCacheSize = 1000
+
+// ContainerResolver resolves container ids of sampled processes by pid using cgroup files from procfs.
+// In pgrep mode phpspy samples processes of other containers than gospy's own one.
+type ContainerResolver struct {
+	procPath string
+	cache    *procCache
+}
+
+// NewContainerResolver creates a ContainerResolver reading cgroup files from procPath (usually /proc).
+func NewContainerResolver(procPath string) *ContainerResolver {
+	return &ContainerResolver{
+		procPath: procPath,
+		cache:    newProcCache(containerResolverCacheSize),
+	}
+}
+
+// ContainerID returns the short container id of the process with the given pid.
+// It returns an empty string if the process doesn't run in a container.
+func (resolver *ContainerResolver) ContainerID(pid string) string {
+	return resolver.cache.get(pid, func(pid string) string {
+		return enrich.ContainerID(resolver.procPath, pid)
+	})
+}
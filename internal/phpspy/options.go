@@ -0,0 +1,225 @@
+package phpspy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSleepNs    = 10101010
+	defaultBufferSize = 4096
+	defaultThreads    = 16
+	defaultMaxDepth   = -1
+	nanosecondsInSec  = 1000000000
+)
+
+// Options is the phpspy command line parsed into typed settings.
+type Options struct {
+	PID                 int
+	Pgrep               string
+	Threads             int
+	SleepNs             int
+	PHPVersion          string
+	Limit               int
+	TimeLimitMs         int
+	MaxDepth            int
+	RequestInfo         string
+	MemoryUsage         bool
+	Output              string
+	ChildStdout         string
+	ChildStderr         string
+	AddrExecutorGlobals string
+	AddrSapiGlobals     string
+	SingleLine          bool
+	BufferSize          int
+	Filter              string
+	FilterNegate        string
+	VerboseFields       string
+	ContinueOnError     bool
+	EventHandler        string
+	EventHandlerOpts    string
+	PauseProcess        bool
+	PeekVars            []string
+	PeekGlobals         []string
+	Top                 bool
+	Help                bool
+	Version             bool
+	// Command is the command phpspy runs and profiles, it's set when neither pid nor pgrep is used
+	Command []string
+}
+
+// option describes a single phpspy command line option.
+type option struct {
+	short    byte
+	long     string
+	hasValue bool
+	apply    func(options *Options, value string) error
+}
+
+// phpspyOptions is the list of options supported by phpspy 0.7.
+var phpspyOptions = []option{
+	{'h', "help", false, func(o *Options, _ string) error { o.Help = true; return nil }},
+	{'p', "pid", true, intSetter(func(o *Options, v int) { o.PID = v })},
+	{'P', "pgrep", true, func(o *Options, v string) error { o.Pgrep = v; return nil }},
+	{'T', "threads", true, intSetter(func(o *Options, v int) { o.Threads = v })},
+	{'s', "sleep-ns", true, intSetter(func(o *Options, v int) { o.SleepNs = v })},
+	{'H', "rate-hz", true, func(o *Options, v string) error {
+		hz, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if hz <= 0 {
+			return errors.New("rate must be positive")
+		}
+		// phpspy converts the rate to the sleep interval, the last of -s and -H wins
+		o.SleepNs = nanosecondsInSec / hz
+		return nil
+	}},
+	{'V', "php-version", true, func(o *Options, v string) error { o.PHPVersion = v; return nil }},
+	{'l', "limit", true, intSetter(func(o *Options, v int) { o.Limit = v })},
+	{'i', "time-limit-ms", true, intSetter(func(o *Options, v int) { o.TimeLimitMs = v })},
+	{'n', "max-depth", true, intSetter(func(o *Options, v int) { o.MaxDepth = v })},
+	{'r', "request-info", true, func(o *Options, v string) error { o.RequestInfo = v; return nil }},
+	{'m', "memory-usage", false, func(o *Options, _ string) error { o.MemoryUsage = true; return nil }},
+	{'o', "output", true, func(o *Options, v string) error { o.Output = v; return nil }},
+	{'O', "child-stdout", true, func(o *Options, v string) error { o.ChildStdout = v; return nil }},
+	{'E', "child-stderr", true, func(o *Options, v string) error { o.ChildStderr = v; return nil }},
+	{'x', "addr-executor-globals", true, func(o *Options, v string) error { o.AddrExecutorGlobals = v; return nil }},
+	{'a', "addr-sapi-globals", true, func(o *Options, v string) error { o.AddrSapiGlobals = v; return nil }},
+	{'1', "single-line", false, func(o *Options, _ string) error { o.SingleLine = true; return nil }},
+	{'b', "buffer-size", true, intSetter(func(o *Options, v int) { o.BufferSize = v })},
+	{'f', "filter", true, func(o *Options, v string) error { o.Filter = v; return nil }},
+	{'F', "filter-negate", true, func(o *Options, v string) error { o.FilterNegate = v; return nil }},
+	{'d', "verbose-fields", true, func(o *Options, v string) error { o.VerboseFields = v; return nil }},
+	{'c', "continue-on-error", false, func(o *Options, _ string) error { o.ContinueOnError = true; return nil }},
+	{'#', "comment", true, func(*Options, string) error { return nil }},
+	{'@', "nothing", false, func(*Options, string) error { return nil }},
+	{'v', "version", false, func(o *Options, _ string) error { o.Version = true; return nil }},
+	{'j', "event-handler", true, func(o *Options, v string) error { o.EventHandler = v; return nil }},
+	{'J', "event-handler-opts", true, func(o *Options, v string) error { o.EventHandlerOpts = v; return nil }},
+	{'S', "pause-process", false, func(o *Options, _ string) error { o.PauseProcess = true; return nil }},
+	{'e', "peek-var", true, func(o *Options, v string) error { o.PeekVars = append(o.PeekVars, v); return nil }},
+	{'g', "peek-global", true, func(o *Options, v string) error { o.PeekGlobals = append(o.PeekGlobals, v); return nil }},
+	{'t', "top", false, func(o *Options, _ string) error { o.Top = true; return nil }},
+}
+
+func intSetter(set func(options *Options, value int)) func(*Options, string) error {
+	return func(options *Options, value string) error {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		set(options, intValue)
+		return nil
+	}
+}
+
+// ParseArgs parses phpspy arguments the same way phpspy's getopt_long does.
+// It supports --long=value, --long value, -s value, -svalue and combined short flags like -cm.
+func ParseArgs(arguments []string) (*Options, error) {
+	options, _, err := parseArgs(arguments)
+	return options, err
+}
+
+// parseArgs is ParseArgs, which also returns the index of the argument options end at, `--` or the command.
+func parseArgs(arguments []string) (*Options, int, error) {
+	options := &Options{
+		Threads:    defaultThreads,
+		SleepNs:    defaultSleepNs,
+		MaxDepth:   defaultMaxDepth,
+		BufferSize: defaultBufferSize,
+	}
+
+	for i := 0; i < len(arguments); i++ {
+		argument := arguments[i]
+
+		switch {
+		case argument == "--":
+			options.Command = arguments[i+1:]
+			return options, i, nil
+		case strings.HasPrefix(argument, "--"):
+			name, value, hasValue := strings.Cut(argument[2:], "=")
+			opt, found := findLongOption(name)
+			if !found {
+				return nil, 0, fmt.Errorf("unknown phpspy option --%s", name)
+			}
+			if opt.hasValue && !hasValue {
+				if i+1 >= len(arguments) {
+					return nil, 0, fmt.Errorf("phpspy option --%s requires a value", name)
+				}
+				i++
+				value = arguments[i]
+			}
+			if !opt.hasValue && hasValue {
+				return nil, 0, fmt.Errorf("phpspy option --%s doesn't take a value", name)
+			}
+			if err := opt.apply(options, value); err != nil {
+				return nil, 0, fmt.Errorf("invalid value `%s` of phpspy option --%s: %w", value, name, err)
+			}
+		case strings.HasPrefix(argument, "-") && len(argument) > 1:
+			// short options may be combined, the first one taking a value consumes the rest
+			for j := 1; j < len(argument); j++ {
+				opt, found := findShortOption(argument[j])
+				if !found {
+					return nil, 0, fmt.Errorf("unknown phpspy option -%c", argument[j])
+				}
+				value := ""
+				if opt.hasValue {
+					value = argument[j+1:]
+					if value == "" {
+						if i+1 >= len(arguments) {
+							return nil, 0, fmt.Errorf("phpspy option -%c requires a value", opt.short)
+						}
+						i++
+						value = arguments[i]
+					}
+				}
+				if err := opt.apply(options, value); err != nil {
+					return nil, 0, fmt.Errorf("invalid value `%s` of phpspy option -%c: %w", value, opt.short, err)
+				}
+				if opt.hasValue {
+					break
+				}
+			}
+		default:
+			// the first non-option argument starts the command to profile
+			options.Command = arguments[i:]
+			return options, i, nil
+		}
+	}
+
+	return options, len(arguments), nil
+}
+
+func findLongOption(name string) (option, bool) {
+	for _, opt := range phpspyOptions {
+		if opt.long == name {
+			return opt, true
+		}
+	}
+	return option{}, false
+}
+
+func findShortOption(short byte) (option, bool) {
+	for _, opt := range phpspyOptions {
+		if opt.short == short {
+			return opt, true
+		}
+	}
+	return option{}, false
+}
+
+// RateHz returns the sampling rate phpspy runs with.
+func (options *Options) RateHz() int {
+	if options.SleepNs <= 0 {
+		return nanosecondsInSec
+	}
+	return (nanosecondsInSec + options.SleepNs/2) / options.SleepNs
+}
+
+// WritesToStdout reports whether phpspy writes traces to stdout.
+func (options *Options) WritesToStdout() bool {
+	return options.Output == "" || options.Output == "-" || options.Output == "stdout"
+}
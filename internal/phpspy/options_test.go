@@ -0,0 +1,143 @@
+package phpspy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/phpspy"
+)
+
+func TestParseArgs(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		options, err := phpspy.ParseArgs([]string{"-p", "123"})
+		require.NoError(t, err)
+
+		assert.Equal(t, 123, options.PID)
+		assert.Equal(t, 99, options.RateHz())
+		assert.Equal(t, 4096, options.BufferSize)
+		assert.Equal(t, -1, options.MaxDepth)
+		assert.True(t, options.WritesToStdout())
+	})
+
+	t.Run("value forms", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			arguments []string
+		}{
+			{name: "long key with equal sign", arguments: []string{"--rate-hz=25"}},
+			{name: "long key with separate value", arguments: []string{"--rate-hz", "25"}},
+			{name: "short key with separate value", arguments: []string{"-H", "25"}},
+			{name: "short key with attached value", arguments: []string{"-H25"}},
+			{name: "combined short flags with value", arguments: []string{"-cmH25"}},
+			{name: "last rate option wins", arguments: []string{"-s", "1000", "-H", "25"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				options, err := phpspy.ParseArgs(tt.arguments)
+				require.NoError(t, err)
+				assert.Equal(t, 25, options.RateHz())
+			})
+		}
+	})
+
+	t.Run("full command line", func(t *testing.T) {
+		options, err := phpspy.ParseArgs([]string{
+			"--max-depth=-1", "--threads=100", "-H", "25", "--buffer-size=65536", "-J", "m",
+			"--continue-on-error", "--peek-global=server.REQUEST_URI", "-g", "server.REQUEST_METHOD",
+			"-e", "user@/app/a.php:10", "-mr", "uq", "-P", "-x php-fpm",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, &phpspy.Options{
+			Pgrep:            "-x php-fpm",
+			Threads:          100,
+			SleepNs:          40000000,
+			MaxDepth:         -1,
+			RequestInfo:      "uq",
+			MemoryUsage:      true,
+			BufferSize:       65536,
+			ContinueOnError:  true,
+			EventHandlerOpts: "m",
+			PeekVars:         []string{"user@/app/a.php:10"},
+			PeekGlobals:      []string{"server.REQUEST_URI", "server.REQUEST_METHOD"},
+		}, options)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			arguments []string
+			expected  []string
+		}{
+			{name: "after separator", arguments: []string{"-H", "25", "--", "php", "-r", "sleep(1);"}, expected: []string{"php", "-r", "sleep(1);"}},
+			{name: "first non-option", arguments: []string{"-c", "php", "-v"}, expected: []string{"php", "-v"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				options, err := phpspy.ParseArgs(tt.arguments)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, options.Command)
+			})
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			arguments []string
+			err       string
+		}{
+			{name: "unknown long option", arguments: []string{"--unknown"}, err: "unknown phpspy option --unknown"},
+			{name: "unknown short option", arguments: []string{"-cZ"}, err: "unknown phpspy option -Z"},
+			{name: "missing long value", arguments: []string{"--rate-hz"}, err: "phpspy option --rate-hz requires a value"},
+			{name: "missing short value", arguments: []string{"-p"}, err: "phpspy option -p requires a value"},
+			{name: "value for flag", arguments: []string{"--top=true"}, err: "phpspy option --top doesn't take a value"},
+			{name: "invalid integer", arguments: []string{"--buffer-size=big"}, err: "invalid value `big` of phpspy option --buffer-size: strconv.Atoi: parsing \"big\": invalid syntax"},
+			{name: "zero rate", arguments: []string{"-H0"}, err: "invalid value `0` of phpspy option -H: rate must be positive"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := phpspy.ParseArgs(tt.arguments)
+				assert.EqualError(t, err, tt.err)
+			})
+		}
+	})
+}
+
+func TestProfiler_IsConfigurationValid(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments []string
+		err       string
+	}{
+		{name: "pgrep mode", arguments: []string{"-P", "php-fpm", "-b", "65536", "-J", "m"}},
+		{name: "explicit stdout", arguments: []string{"-p", "1", "-o", "-"}},
+		{name: "single-line mode", arguments: []string{"-p", "1", "-1"}},
+		{name: "top mode", arguments: []string{"-p", "1", "-t"}, err: "flag -t/--top is unsupported by gospy"},
+		{name: "combined version flag", arguments: []string{"-cv"}, err: "flag -v/--version is unsupported by gospy"},
+		{name: "output to file", arguments: []string{"-p", "1", "--output", "/tmp/out"}},
+		{name: "callgrind handler", arguments: []string{"-p", "1", "-j", "callgrind"}, err: "event handler callgrind is unsupported by gospy"},
+		{name: "nothing to profile", arguments: []string{"-H", "25"}, err: "phpspy requires -p, -P or a command to profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiler, err := phpspy.NewProfiler("phpspy", tt.arguments)
+			require.NoError(t, err)
+
+			valid, err := profiler.IsConfigurationValid()
+			if tt.err == "" {
+				assert.True(t, valid)
+				assert.NoError(t, err)
+			} else {
+				assert.False(t, valid)
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
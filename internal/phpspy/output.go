@@ -0,0 +1,175 @@
+package phpspy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+const outputPollInterval = 100 * time.Millisecond
+
+// outputFollower reads phpspy output written to a file or a named pipe, like tail -F does.
+// At the end of the output it waits for more data until phpspy exits. A regular file is reopened
+// when it's rotated and read from the beginning when it's truncated. A file which doesn't exist yet
+// is opened once phpspy creates it.
+type outputFollower struct {
+	path    string
+	file    *os.File
+	offset  int64
+	isFifo  bool
+	created bool
+	done    <-chan struct{}
+}
+
+// openOutput opens phpspy output path for following before phpspy is started, an existing regular file
+// is followed from its end. If the path doesn't exist, a named pipe is created there with createFifo,
+// otherwise the file is opened once phpspy creates it.
+func openOutput(path string, createFifo bool) (*outputFollower, error) {
+	follower := &outputFollower{path: path}
+
+	_, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && createFifo:
+		if err := syscall.Mkfifo(path, 0o600); err != nil {
+			return nil, fmt.Errorf("can't create named pipe %s: %w", path, err)
+		}
+		follower.created = true
+	case errors.Is(err, os.ErrNotExist):
+		return follower, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := follower.open(true); err != nil {
+		follower.Close()
+		return nil, err
+	}
+
+	return follower, nil
+}
+
+// open opens the output, a regular file is read from its end if fromEnd is set.
+func (follower *outputFollower) open(fromEnd bool) error {
+	info, err := os.Stat(follower.path)
+	if err != nil {
+		return err
+	}
+
+	follower.isFifo = info.Mode()&os.ModeNamedPipe != 0
+	if follower.isFifo {
+		// non-blocking open doesn't wait for phpspy to open the pipe for writing
+		follower.file, err = os.OpenFile(follower.path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		return err
+	}
+
+	follower.file, err = os.Open(follower.path)
+	if err == nil && fromEnd {
+		follower.offset, err = follower.file.Seek(0, io.SeekEnd)
+	}
+	return err
+}
+
+// follow makes the follower return io.EOF once done is closed and the output is drained.
+func (follower *outputFollower) follow(done <-chan struct{}) {
+	follower.done = done
+}
+
+func (follower *outputFollower) Read(p []byte) (int, error) {
+	for {
+		n, err := follower.read(p)
+		if n > 0 || err != nil {
+			return n, err
+		}
+
+		select {
+		case <-follower.done:
+			// phpspy has exited, read the rest of what it has written
+			if n, err = follower.read(p); n > 0 || err != nil {
+				return n, err
+			}
+			return 0, io.EOF
+		case <-time.After(outputPollInterval):
+		}
+
+		if follower.file != nil && !follower.isFifo {
+			if err := follower.checkRotation(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// read reads available data, reaching the end of the output isn't an error for the follower.
+func (follower *outputFollower) read(p []byte) (int, error) {
+	if follower.file == nil {
+		// phpspy hasn't created the output yet, it's read from the beginning once it has
+		if err := follower.open(false); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return 0, nil
+			}
+			return 0, err
+		}
+	}
+
+	n, err := follower.file.Read(p)
+	follower.offset += int64(n)
+	if n > 0 || errors.Is(err, io.EOF) {
+		return n, nil
+	}
+	return n, err
+}
+
+// checkRotation reopens the file if it has been replaced and rewinds it if it has been truncated.
+// A replaced file is reopened only after it has been read to the end.
+func (follower *outputFollower) checkRotation() error {
+	info, err := os.Stat(follower.path)
+	if err != nil {
+		// the file may be recreated right after rotation
+		return nil
+	}
+
+	current, err := follower.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(info, current) {
+		// lines written before the rotation are read first, the new file is opened once the old one is drained
+		if current.Size() > follower.offset {
+			return nil
+		}
+		file, err := os.Open(follower.path)
+		if err != nil {
+			return nil
+		}
+		_ = follower.file.Close()
+		follower.file, follower.offset = file, 0
+		return nil
+	}
+
+	if info.Size() < follower.offset {
+		if _, err := follower.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		follower.offset = 0
+	}
+
+	return nil
+}
+
+// Close closes the output and removes the named pipe if it was created by gospy.
+func (follower *outputFollower) Close() error {
+	var err error
+	if follower.file != nil {
+		err = follower.file.Close()
+	}
+	if follower.created {
+		if removeErr := os.Remove(follower.path); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
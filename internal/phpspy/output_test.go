@@ -0,0 +1,212 @@
+package phpspy
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePhpspyScript writes the trace passed as -# comment to the file passed with -o.
+const fakePhpspyScript = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -o) output="$2"; shift ;;
+    -#) trace="$2"; shift ;;
+  esac
+  shift
+done
+printf "$trace" >> "$output"
+`
+
+func newFakePhpspy(t *testing.T) string {
+	t.Helper()
+	executable := filepath.Join(t.TempDir(), "phpspy")
+	require.NoError(t, os.WriteFile(executable, []byte(fakePhpspyScript), 0o755))
+	return executable
+}
+
+func scanLines(scanner *bufio.Scanner) []string {
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestProfiler_StartWithOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, path string)
+		options []ProfilerOption
+	}{
+		{
+			name:    "named pipe created by gospy",
+			prepare: func(t *testing.T, path string) {},
+			options: []ProfilerOption{WithOutputFifo()},
+		},
+		{
+			name:    "missing file is read once phpspy creates it",
+			prepare: func(t *testing.T, path string) {},
+		},
+		{
+			name: "existing file is followed from its end",
+			prepare: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, []byte("0 old /app/old.php:1\n\n"), 0o644))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "phpspy.out")
+			tt.prepare(t, output)
+
+			profiler, err := NewProfiler(newFakePhpspy(t), []string{"-p", "1", "-o", output, "-#", `0 func /app/a.php:1\n1 main /app/index.php:1\n`}, tt.options...)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			scanner, err := profiler.Start(ctx)
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"0 func /app/a.php:1", "1 main /app/index.php:1"}, scanLines(scanner))
+			require.NoError(t, profiler.Wait())
+		})
+	}
+
+	t.Run("named pipe is removed after exit", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "phpspy.out")
+		profiler, err := NewProfiler(newFakePhpspy(t), []string{"-p", "1", "-o", output}, WithOutputFifo())
+		require.NoError(t, err)
+
+		scanner, err := profiler.Start(context.Background())
+		require.NoError(t, err)
+		scanLines(scanner)
+		require.NoError(t, profiler.Wait())
+
+		assert.NoFileExists(t, output)
+	})
+}
+
+func TestOutputFollower(t *testing.T) {
+	newFollower := func(t *testing.T) (string, *outputFollower, chan struct{}) {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "phpspy.out")
+		require.NoError(t, os.WriteFile(path, []byte("skipped\n"), 0o644))
+
+		follower, err := openOutput(path, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = follower.Close() })
+
+		done := make(chan struct{})
+		follower.follow(done)
+		return path, follower, done
+	}
+
+	// appendTo is called by writer goroutines, so it uses assert, FailNow must be called by the test goroutine
+	appendTo := func(t *testing.T, path, content string) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = file.WriteString(content)
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+	}
+
+	t.Run("waits for new data", func(t *testing.T) {
+		path, follower, done := newFollower(t)
+		scanner := bufio.NewScanner(follower)
+
+		go func() {
+			time.Sleep(2 * outputPollInterval)
+			appendTo(t, path, "first\n")
+			time.Sleep(2 * outputPollInterval)
+			appendTo(t, path, "second\n")
+			close(done)
+		}()
+
+		assert.Equal(t, []string{"first", "second"}, scanLines(scanner))
+	})
+
+	t.Run("missing file is read once it's created", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "phpspy.out")
+		follower, err := openOutput(path, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = follower.Close() })
+		done := make(chan struct{})
+		follower.follow(done)
+		scanner := bufio.NewScanner(follower)
+
+		go func() {
+			time.Sleep(2 * outputPollInterval)
+			appendTo(t, path, "first\n")
+			time.Sleep(2 * outputPollInterval)
+			appendTo(t, path, "second\n")
+			close(done)
+		}()
+
+		assert.Equal(t, []string{"first", "second"}, scanLines(scanner))
+		fileInfo, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, fileInfo.Mode().IsRegular(), "a named pipe must not be created")
+	})
+
+	t.Run("truncated file is read from the beginning", func(t *testing.T) {
+		path, follower, done := newFollower(t)
+		scanner := bufio.NewScanner(follower)
+
+		go func() {
+			appendTo(t, path, "first\n")
+			time.Sleep(2 * outputPollInterval)
+			assert.NoError(t, os.Truncate(path, 0))
+			appendTo(t, path, "second\n")
+			time.Sleep(2 * outputPollInterval)
+			close(done)
+		}()
+
+		assert.Equal(t, []string{"first", "second"}, scanLines(scanner))
+	})
+
+	t.Run("rotated file is reopened", func(t *testing.T) {
+		path, follower, done := newFollower(t)
+		scanner := bufio.NewScanner(follower)
+
+		go func() {
+			appendTo(t, path, "first\n")
+			time.Sleep(2 * outputPollInterval)
+			assert.NoError(t, os.Rename(path, path+".1"))
+			appendTo(t, path, "second\n")
+			time.Sleep(2 * outputPollInterval)
+			close(done)
+		}()
+
+		assert.Equal(t, []string{"first", "second"}, scanLines(scanner))
+	})
+
+	t.Run("lines written right before rotation are read", func(t *testing.T) {
+		path, follower, done := newFollower(t)
+		scanner := bufio.NewScanner(follower)
+
+		go func() {
+			appendTo(t, path, "first\n")
+			time.Sleep(2 * outputPollInterval)
+			// the follower is waiting for data, the lines and the rotation happen before its next read
+			appendTo(t, path, "second\nthird\n")
+			assert.NoError(t, os.Rename(path, path+".1"))
+			appendTo(t, path, "fourth\n")
+			time.Sleep(3 * outputPollInterval)
+			close(done)
+		}()
+
+		assert.Equal(t, []string{"first", "second", "third", "fourth"}, scanLines(scanner))
+	})
+}
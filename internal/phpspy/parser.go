@@ -2,13 +2,20 @@ package phpspy
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/enrich"
+	"github.com/hakastein/gospy/internal/stack"
 	"github.com/hakastein/gospy/internal/tag"
 	"github.com/hakastein/gospy/internal/transform"
 	lru "github.com/hashicorp/golang-lru"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/hakastein/gospy/internal/validator"
 	"github.com/rs/zerolog/log"
@@ -17,17 +24,147 @@ import (
 const (
 	entryPointValidatorCacheSize = 1000
 	traceCapacity                = 100
+	pidMetaPrefix                = "# pid = "
+	memMetaPrefix                = "# mem "
+	// DefaultMaxLineSize is the default size limit of a phpspy output line, glopeek values may be long.
+	DefaultMaxLineSize = 1024 * 1024
 )
 
 type Parser struct {
-	entryPoints        []string
-	tagsMapping        map[string][]tag.DynamicTag
-	tagEntrypoint      bool
-	keepEntrypointName bool
-	currentTrace       []string
-	currentMeta        []string
-	tags               strings.Builder
-	epValidator        *validator.EntryPointValidator
+	entryPoints   []string
+	tagsMapping   map[string][]tag.DynamicTag
+	tagEntrypoint bool
+	foldOptions   transform.FoldOptions
+	tagPid        bool
+	poolResolver  *PoolResolver
+	containers    *ContainerResolver
+	memoryProfile bool
+	sampler       *Sampler
+	appRouter     *AppRouter
+	stackTables   *stack.Tables
+	statsInterval time.Duration
+	maxLineSize   int
+	// truncatedSamples counts samples cut to the max depth since the last statistics report
+	truncatedSamples atomic.Int64
+	// droppedTraces counts traces with a line over the max line size since the last statistics report
+	droppedTraces atomic.Int64
+	// lineDropped is set by the split function when it drops a line, the current trace is discarded then
+	lineDropped  bool
+	currentTrace []string
+	currentMeta  []string
+	frames       []string
+	tags         strings.Builder
+	epValidator  *validator.EntryPointValidator
+	// lines holds lines of the current trace and metadata, loadLines views them as strings without copying
+	lines     []byte
+	lineSpans []lineSpan
+}
+
+// lineSpan is the end of a line in Parser.lines and whether it's a metadata line.
+type lineSpan struct {
+	end  int
+	meta bool
+}
+
+// Option configures optional Parser features.
+type Option func(parser *Parser)
+
+// WithPidTag adds the pid reported by phpspy (-d p) to the sample tags.
+func WithPidTag() Option {
+	return func(parser *Parser) {
+		parser.tagPid = true
+	}
+}
+
+// WithPoolTag adds the php-fpm pool name of the sampled worker to the sample tags.
+func WithPoolTag(resolver *PoolResolver) Option {
+	return func(parser *Parser) {
+		parser.poolResolver = resolver
+	}
+}
+
+// WithContainerTag adds the container id of the sampled process to the sample tags.
+func WithContainerTag(resolver *ContainerResolver) Option {
+	return func(parser *Parser) {
+		parser.containers = resolver
+	}
+}
+
+// WithMemoryProfile sends the memory usage reported by phpspy (-m) as memory profile samples along with CPU samples.
+func WithMemoryProfile() Option {
+	return func(parser *Parser) {
+		parser.memoryProfile = true
+	}
+}
+
+// WithLines keeps file and line information of frames in folded stacks.
+func WithLines(mode transform.LineMode) Option {
+	return func(parser *Parser) {
+		parser.foldOptions.Lines = mode
+	}
+}
+
+// WithFrameRules rewrites and filters frames of each trace before it's folded.
+func WithFrameRules(rules transform.FrameRules) Option {
+	return func(parser *Parser) {
+		parser.foldOptions.Rules = rules
+	}
+}
+
+// WithSampling down-samples traces by entry point, kept CPU samples are weighted by the rule ratio.
+func WithSampling(sampler *Sampler) Option {
+	return func(parser *Parser) {
+		parser.sampler = sampler
+	}
+}
+
+// WithAppRouter sends samples to the Pyroscope application picked by the router.
+func WithAppRouter(router *AppRouter) Option {
+	return func(parser *Parser) {
+		parser.appRouter = router
+	}
+}
+
+// WithStackTables interns stacks of samples in the tables shared with trace collectors instead of folding them.
+func WithStackTables(tables *stack.Tables) Option {
+	return func(parser *Parser) {
+		parser.stackTables = tables
+	}
+}
+
+// WithPathNormalizer normalizes the entry point and file paths of traces before entry points are validated.
+func WithPathNormalizer(normalizer *transform.PathNormalizer) Option {
+	return func(parser *Parser) {
+		parser.foldOptions.Paths = normalizer
+	}
+}
+
+// WithStackFilter keeps or drops whole traces based on whether their frames match focus and ignore patterns.
+func WithStackFilter(filter *transform.StackFilter) Option {
+	return func(parser *Parser) {
+		parser.foldOptions.Filter = filter
+	}
+}
+
+// WithMaxDepth limits stacks to the root-most maxDepth frames, the cut is marked with a [truncated] frame.
+func WithMaxDepth(maxDepth int) Option {
+	return func(parser *Parser) {
+		parser.foldOptions.MaxDepth = maxDepth
+	}
+}
+
+// WithStatsInterval logs parser statistics, such as the number of truncated samples, at the given interval.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(parser *Parser) {
+		parser.statsInterval = interval
+	}
+}
+
+// WithMaxLineSize sets the size limit of a phpspy output line, traces with longer lines are dropped.
+func WithMaxLineSize(size int) Option {
+	return func(parser *Parser) {
+		parser.maxLineSize = size
+	}
 }
 
 // NewParser initializes a new Parser.
@@ -36,21 +173,31 @@ func NewParser(
 	tagsMapping map[string][]tag.DynamicTag,
 	tagEntrypoint bool,
 	keepEntrypointName bool,
+	options ...Option,
 ) *Parser {
 	cache, err := lru.New(entryPointValidatorCacheSize)
 	if err != nil {
 		panic("failed to create LRU cache: " + err.Error())
 	}
 
-	return &Parser{
-		entryPoints:        entryPoints,
-		tagsMapping:        tagsMapping,
-		tagEntrypoint:      tagEntrypoint,
-		keepEntrypointName: keepEntrypointName,
-		currentTrace:       make([]string, 0, traceCapacity),
-		currentMeta:        make([]string, 0, len(tagsMapping)),
-		epValidator:        validator.New(entryPoints, cache),
+	parser := &Parser{
+		entryPoints:   entryPoints,
+		tagsMapping:   tagsMapping,
+		tagEntrypoint: tagEntrypoint,
+		foldOptions:   transform.FoldOptions{KeepEntrypointName: keepEntrypointName},
+		maxLineSize:   DefaultMaxLineSize,
+		lineSpans:     make([]lineSpan, 0, traceCapacity),
+		currentTrace:  make([]string, 0, traceCapacity),
+		currentMeta:   make([]string, 0, len(tagsMapping)),
+		frames:        make([]string, 0, traceCapacity),
+		epValidator:   validator.New(entryPoints, cache),
+	}
+
+	for _, option := range options {
+		option(parser)
 	}
+
+	return parser
 }
 
 // Parse reads and processes lines from the scanner, converting them into folded stack samples.
@@ -59,6 +206,40 @@ func (parser *Parser) Parse(
 	scanner *bufio.Scanner,
 	foldedStacks chan<- *collector.Sample,
 ) {
+	parser.scanOutput(ctx, scanner, func(line []byte) {
+		if trimmed := bytes.TrimSpace(line); len(trimmed) == 0 {
+			if !parser.discardTrace() {
+				parser.processTrace(foldedStacks)
+			}
+			return
+		}
+
+		// the rest of a trace with a dropped line is skipped until the blank line ending it
+		if parser.lineDropped {
+			return
+		}
+
+		if line[0] == '#' {
+			parser.addToMeta(line)
+			return
+		}
+
+		parser.addToTrace(line)
+	})
+}
+
+// scanOutput passes lines from the scanner to handleLine until the scanner is closed or ctx is done.
+// Lines are only valid until handleLine returns, the scanner reuses its buffer.
+func (parser *Parser) scanOutput(ctx context.Context, scanner *bufio.Scanner, handleLine func(line []byte)) {
+	scanner.Buffer(nil, parser.maxLineSize)
+	scanner.Split(parser.splitLines())
+
+	if parser.statsInterval > 0 {
+		statsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go parser.reportStats(statsCtx)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -72,29 +253,105 @@ func (parser *Parser) Parse(
 				return
 			}
 
-			line := scanner.Text()
+			handleLine(scanner.Bytes())
+		}
+	}
+}
 
-			if trimmed := strings.TrimSpace(line); trimmed == "" {
-				parser.processTrace(foldedStacks)
-				continue
+// splitLines returns a split function like bufio.ScanLines, which drops lines longer than the max line size
+// instead of failing the scanner, so an oversized glopeek value doesn't stop reading phpspy output.
+// It sets lineDropped, so the trace of the line is discarded instead of being sent without a frame or tags.
+func (parser *Parser) splitLines() bufio.SplitFunc {
+	dropping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if dropping {
+			end := bytes.IndexByte(data, '\n')
+			if end == -1 {
+				return len(data), nil, nil
 			}
+			dropping = false
+			return end + 1, nil, nil
+		}
 
-			if strings.HasPrefix(line, "#") {
-				parser.addToMeta(line)
-				continue
-			}
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		// the scanner buffer is full without a line end, the rest of the line is dropped as it's read
+		if advance == 0 && err == nil && len(data) >= parser.maxLineSize {
+			log.Warn().
+				Int("max_line_size", parser.maxLineSize).
+				Msg("Dropped phpspy trace with a line exceeding the max line size, consider increasing --max-line-mb")
+			dropping = true
+			parser.lineDropped = true
+			return len(data), nil, nil
+		}
+		return advance, token, err
+	}
+}
+
+// reportStats logs parser statistics every statsInterval until ctx is done.
+func (parser *Parser) reportStats(ctx context.Context) {
+	ticker := time.NewTicker(parser.statsInterval)
+	defer ticker.Stop()
 
-			parser.addToTrace(line)
+	for {
+		select {
+		case <-ticker.C:
+			truncated := parser.truncatedSamples.Swap(0)
+			dropped := parser.droppedTraces.Swap(0)
+			if truncated > 0 || dropped > 0 {
+				log.Info().
+					Int64("truncated_samples", truncated).
+					Int("max_depth", parser.foldOptions.MaxDepth).
+					Int64("dropped_traces", dropped).
+					Int("max_line_size", parser.maxLineSize).
+					Msg("parser statistics")
+			}
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func (parser *Parser) addToTrace(line string) {
-	parser.currentTrace = append(parser.currentTrace, line)
+// discardTrace drops the current trace if one of its lines was dropped and returns whether it was dropped.
+func (parser *Parser) discardTrace() bool {
+	if !parser.lineDropped {
+		return false
+	}
+	parser.lineDropped = false
+	parser.droppedTraces.Add(1)
+	parser.resetState()
+	return true
+}
+
+func (parser *Parser) addToTrace(line []byte) {
+	parser.addLine(line, false)
+}
+
+func (parser *Parser) addToMeta(line []byte) {
+	parser.addLine(line, true)
 }
 
-func (parser *Parser) addToMeta(line string) {
-	parser.currentMeta = append(parser.currentMeta, line)
+// addLine copies the line to the lines of the current trace, the scanner overwrites it with the next line.
+func (parser *Parser) addLine(line []byte, meta bool) {
+	parser.lines = append(parser.lines, line...)
+	parser.lineSpans = append(parser.lineSpans, lineSpan{end: len(parser.lines), meta: meta})
+}
+
+// loadLines fills the current trace and metadata with the lines without copying them.
+// The strings point into the lines buffer, which is overwritten by the next trace, so everything kept
+// after processTrace must be copied: the stack table and the caches of validators and resolvers clone
+// their keys, tags are built in a strings.Builder and folded stacks are joined.
+func (parser *Parser) loadLines() {
+	text := unsafe.String(unsafe.SliceData(parser.lines), len(parser.lines))
+	start := 0
+	for _, span := range parser.lineSpans {
+		line := text[start:span.end]
+		start = span.end
+		if span.meta {
+			parser.currentMeta = append(parser.currentMeta, line)
+		} else {
+			parser.currentTrace = append(parser.currentTrace, line)
+		}
+	}
 }
 
 // processTrace converts the current trace to a folded stack and sends it to the foldedStacks channel.
@@ -103,11 +360,19 @@ func (parser *Parser) processTrace(
 ) {
 	defer parser.resetState()
 
+	parser.loadLines()
 	if len(parser.currentTrace) == 0 {
 		return
 	}
 
-	sample, entryPoint, convertError := transform.TracesToFoldedStacks(parser.currentTrace, parser.keepEntrypointName)
+	frames, entryPoint, truncated, convertError := transform.AppendFrames(
+		parser.frames[:0],
+		parser.currentTrace,
+		parser.foldOptions,
+	)
+	if errors.Is(convertError, transform.ErrStackFiltered) {
+		return
+	}
 	if convertError != nil {
 		log.Debug().
 			Err(convertError).
@@ -115,6 +380,10 @@ func (parser *Parser) processTrace(
 			Msg("Failed to convert trace")
 		return
 	}
+	if cap(frames) > cap(parser.frames) {
+		// the grown slice is reused for next traces
+		parser.frames = frames[:0]
+	}
 
 	if !parser.epValidator.IsValid(entryPoint) {
 		log.Debug().
@@ -123,28 +392,143 @@ func (parser *Parser) processTrace(
 		return
 	}
 
+	weight := 1
+	if parser.sampler != nil {
+		var keep bool
+		if keep, weight = parser.sampler.Sample(entryPoint); !keep {
+			return
+		}
+	}
+
+	if truncated {
+		parser.truncatedSamples.Add(1)
+	}
+
+	// with a stack table stacks are interned, otherwise they're sent in folded format
+	var (
+		stackID = stack.Root
+		table   *stack.Table
+		sample  string
+	)
+	if parser.stackTables != nil {
+		table = parser.stackTables.Current()
+		stackID = table.Intern(frames)
+	} else {
+		sample = joinFrames(frames)
+	}
+
 	parser.buildTags(entryPoint)
-	foldedStacks <- &collector.Sample{Trace: sample, Tags: parser.tags.String(), Time: time.Now()}
+	tags := parser.tags.String()
+	app := ""
+	if parser.appRouter != nil {
+		app = parser.appRouter.Route(entryPoint, tags)
+	}
+	now := time.Now()
+	cpuSample := &collector.Sample{Stack: stackID, Table: table, Trace: sample, Tags: tags, App: app, Time: now}
+	if weight > 1 {
+		cpuSample.Value = weight
+	}
+	foldedStacks <- cpuSample
+	if parser.memoryProfile {
+		if memory, ok := parser.currentMemory(); ok {
+			foldedStacks <- &collector.Sample{
+				Stack:   stackID,
+				Table:   table,
+				Trace:   sample,
+				Tags:    tags,
+				App:     app,
+				Time:    now,
+				Profile: collector.ProfileMemory,
+				Value:   memory,
+			}
+		}
+	}
 	log.Trace().
-		Str("sample", sample).
+		Strs("frames", frames).
 		Msg("Trace processed")
 }
 
-// buildTags constructs the tags string based on metadata and entry point.
+// joinFrames returns the frames in folded format. strings.Join returns a single frame as is,
+// it's cloned as it may point into the lines buffer.
+func joinFrames(frames []string) string {
+	if len(frames) == 1 {
+		return strings.Clone(frames[0])
+	}
+	return strings.Join(frames, ";")
+}
+
+// buildTags constructs the tags string based on metadata, process info and entry point.
 func (parser *Parser) buildTags(entryPoint string) {
-	parsedTags := transform.MetaToTags(parser.currentMeta, parser.tagsMapping)
-	parser.tags.WriteString(parsedTags)
+	parser.tags.WriteString(transform.MetaToTags(parser.currentMeta, parser.tagsMapping))
+
+	if parser.tagPid || parser.poolResolver != nil || parser.containers != nil {
+		if pid := parser.currentPid(); pid != "" {
+			if parser.tagPid {
+				parser.addTag("pid", pid)
+			}
+			if parser.poolResolver != nil {
+				if pool := parser.poolResolver.Pool(pid); pool != "" {
+					parser.addTag("pool", pool)
+				}
+			}
+			if parser.containers != nil {
+				if containerID := parser.containers.ContainerID(pid); containerID != "" {
+					parser.addTag(enrich.ContainerIDTag, containerID)
+				}
+			}
+		}
+	}
+
 	if parser.tagEntrypoint {
-		if parsedTags != "" {
-			parser.tags.WriteRune(',')
+		parser.addTag("entrypoint", entryPoint)
+	}
+}
+
+// addTag appends a key=value pair to the tags being built.
+func (parser *Parser) addTag(key, value string) {
+	if parser.tags.Len() > 0 {
+		parser.tags.WriteRune(',')
+	}
+	parser.tags.WriteString(key)
+	parser.tags.WriteRune('=')
+	parser.tags.WriteString(strings.ReplaceAll(value, ",", "͵"))
+}
+
+// currentPid returns the pid phpspy reports for the current trace with verbose fields enabled.
+func (parser *Parser) currentPid() string {
+	for _, line := range parser.currentMeta {
+		if strings.HasPrefix(line, pidMetaPrefix) {
+			return strings.TrimSpace(line[len(pidMetaPrefix):])
+		}
+	}
+	return ""
+}
+
+// currentMemory returns the memory usage in bytes phpspy reports for the current trace with -m enabled.
+// The metadata line holds the current and the peak usage, only the current one is used.
+func (parser *Parser) currentMemory() (int, bool) {
+	for _, line := range parser.currentMeta {
+		if !strings.HasPrefix(line, memMetaPrefix) {
+			continue
+		}
+		fields := strings.Fields(line[len(memMetaPrefix):])
+		if len(fields) == 0 {
+			return 0, false
+		}
+		memory, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Debug().Err(err).Str("meta", line).Msg("Failed to parse memory usage")
+			return 0, false
 		}
-		parser.tags.WriteString("entrypoint=")
-		parser.tags.WriteString(entryPoint)
+		return memory, true
 	}
+	return 0, false
 }
 
 // resetState clears the current trace, metadata, and tags for the next parsing session.
 func (parser *Parser) resetState() {
+	parser.lines = parser.lines[:0]
+	parser.lineSpans = parser.lineSpans[:0]
 	parser.currentTrace = parser.currentTrace[:0]
 	parser.currentMeta = parser.currentMeta[:0]
 	parser.tags.Reset()
@@ -2,15 +2,21 @@ package phpspy_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/hakastein/gospy/internal/collector"
 	"github.com/hakastein/gospy/internal/phpspy"
+	"github.com/hakastein/gospy/internal/stack"
 	"github.com/hakastein/gospy/internal/tag"
+	"github.com/hakastein/gospy/internal/validator"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,6 +27,7 @@ type parserTestCase struct {
 	tagsMapping        map[string][]tag.DynamicTag
 	tagEntrypoint      bool
 	keepEntrypointName bool
+	options            []phpspy.Option
 	expectedSamples    []collector.Sample // verify exact data, not just count
 }
 
@@ -29,7 +36,34 @@ func newScannerFromInput(input []string) *bufio.Scanner {
 	return bufio.NewScanner(strings.NewReader(inputStr))
 }
 
+// newProcFromCmdlines creates fake procfs with cmdline files for the given pids.
+func newProcFromCmdlines(t *testing.T, cmdlines map[string]string) string {
+	t.Helper()
+	procPath := t.TempDir()
+	for pid, cmdline := range cmdlines {
+		require.NoError(t, os.MkdirAll(filepath.Join(procPath, pid), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procPath, pid, "cmdline"), []byte(cmdline), 0o644))
+	}
+	return procPath
+}
+
+func mustParsePattern(pattern string) validator.Pattern {
+	parsed, err := validator.ParsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
 func TestParser_Parse(t *testing.T) {
+	procPath := newProcFromCmdlines(t, map[string]string{
+		"101": "php-fpm: pool www\x00\x00\x00",
+		"102": "php-fpm: pool api\x00\x00\x00",
+		"103": "php-fpm: master process (/etc/php-fpm.conf)\x00",
+	})
+	cgroup := "0::/system.slice/docker-3f4e5d6c7b8a99887766554433221100ffeeddccbbaa99887766554433221100.scope"
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "101", "cgroup"), []byte(cgroup), 0o644))
+
 	testCases := []parserTestCase{
 		{
 			name: "entrypoint filtering - allows only matching entrypoints",
@@ -98,6 +132,109 @@ func TestParser_Parse(t *testing.T) {
 				{Trace: "main;func2", Tags: "test=value2,entrypoint=/app/test.php"},
 			},
 		},
+		{
+			name: "process tags - adds pid and fpm pool reported in pgrep mode",
+			input: []string{
+				"# pid = 101\n0 func1 /app/some/helper.php:10\n1 main /app/test.php:1",
+				"0 func2 /app/some/helper.php:20\n1 main /app/test.php:1\n# pid = 102",
+				"# pid = 103\n0 func3 /app/some/helper.php:30\n1 main /app/test.php:1",
+				"0 func4 /app/some/helper.php:40\n1 main /app/test.php:1",
+			},
+			tagsMapping:   map[string][]tag.DynamicTag{"glopeek test.key": {{TagKey: "test"}}},
+			tagEntrypoint: true,
+			options:       []phpspy.Option{phpspy.WithPidTag(), phpspy.WithPoolTag(phpspy.NewPoolResolver(procPath))},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "pid=101,pool=www,entrypoint=/app/test.php"},
+				{Trace: "main;func2", Tags: "pid=102,pool=api,entrypoint=/app/test.php"},
+				{Trace: "main;func3", Tags: "pid=103,entrypoint=/app/test.php"},
+				{Trace: "main;func4", Tags: "entrypoint=/app/test.php"},
+			},
+		},
+		{
+			name: "process tags - adds container ids of sampled processes",
+			input: []string{
+				"# pid = 101\n0 func1 /app/some/helper.php:10\n1 main /app/test.php:1",
+				"# pid = 103\n0 func2 /app/some/helper.php:20\n1 main /app/test.php:1",
+			},
+			options: []phpspy.Option{phpspy.WithContainerTag(phpspy.NewContainerResolver(procPath))},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "container_id=3f4e5d6c7b8a"},
+				{Trace: "main;func2", Tags: ""},
+			},
+		},
+		{
+			name: "process tags - pool only, combined with metadata tags",
+			input: []string{
+				"# glopeek test.key = value1\n# pid = 101\n0 func1 /app/some/helper.php:10\n1 main /app/test.php:1",
+				"# pid = 999\n0 func2 /app/some/helper.php:20\n1 main /app/test.php:1",
+			},
+			tagsMapping: map[string][]tag.DynamicTag{"glopeek test.key": {{TagKey: "test"}}},
+			options:     []phpspy.Option{phpspy.WithPoolTag(phpspy.NewPoolResolver(procPath))},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "test=value1,pool=www"},
+				{Trace: "main;func2", Tags: ""},
+			},
+		},
+		{
+			name: "memory profile - adds memory samples reported with -m",
+			input: []string{
+				"# mem 2097152 4194304\n0 func1 /app/some/helper.php:10\n1 main /app/test.php:1",
+				"0 func2 /app/some/helper.php:20\n1 main /app/test.php:1",
+				"# mem invalid 0\n0 func3 /app/some/helper.php:30\n1 main /app/test.php:1",
+			},
+			tagEntrypoint: true,
+			options:       []phpspy.Option{phpspy.WithMemoryProfile()},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "entrypoint=/app/test.php"},
+				{Trace: "main;func1", Tags: "entrypoint=/app/test.php", Profile: collector.ProfileMemory, Value: 2097152},
+				{Trace: "main;func2", Tags: "entrypoint=/app/test.php"},
+				{Trace: "main;func3", Tags: "entrypoint=/app/test.php"},
+			},
+		},
+		{
+			name: "max depth - keeps root-most frames and marks the cut",
+			input: []string{
+				"0 func1 /app/some/helper.php:10\n1 func1 /app/some/helper.php:10\n2 main /app/test.php:1",
+				"0 func2 /app/some/helper.php:20\n1 main /app/test.php:1",
+			},
+			options: []phpspy.Option{phpspy.WithMaxDepth(2)},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1;[truncated]", Tags: ""},
+				{Trace: "main;func2", Tags: ""},
+			},
+		},
+		{
+			name: "sampling - keeps every Nth sample of matching entrypoints with its weight",
+			input: []string{
+				"0 func1 /app/some/helper.php:10\n1 main /app/index.php:1",
+				"0 func2 /app/some/helper.php:20\n1 main /app/index.php:1",
+				"0 func3 /app/some/helper.php:30\n1 main /app/cron.php:1",
+				"0 func4 /app/some/helper.php:40\n1 main /app/index.php:1",
+			},
+			options: []phpspy.Option{phpspy.WithSampling(phpspy.NewSampler([]phpspy.SamplingRule{
+				{Pattern: mustParsePattern("index.php"), Ratio: 2},
+			}))},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "", Value: 2},
+				{Trace: "main;func3", Tags: ""},
+				{Trace: "main;func4", Tags: "", Value: 2},
+			},
+		},
+		{
+			name: "app routes",
+			input: []string{
+				"0 func1 /app/some/helper.php:10\n1 main /var/www/shop/index.php:1",
+				"0 func2 /app/some/helper.php:20\n1 main /var/www/blog/index.php:1",
+			},
+			tagEntrypoint: true,
+			options: []phpspy.Option{phpspy.WithAppRouter(phpspy.NewAppRouter([]phpspy.AppRoute{
+				{Kind: phpspy.RoutePrefix, Prefix: "/var/www/shop/", App: "shop"},
+			}))},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "entrypoint=/var/www/shop/index.php", App: "shop"},
+				{Trace: "main;func2", Tags: "entrypoint=/var/www/blog/index.php"},
+			},
+		},
 		{
 			name: "scanner line processing - handles empty lines and whitespace",
 			input: []string{
@@ -149,7 +286,7 @@ func TestParser_Parse(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			parser := phpspy.NewParser(tc.entryPoints, tc.tagsMapping, tc.tagEntrypoint, tc.keepEntrypointName)
+			parser := phpspy.NewParser(tc.entryPoints, tc.tagsMapping, tc.tagEntrypoint, tc.keepEntrypointName, tc.options...)
 
 			scanner := newScannerFromInput(tc.input)
 			samplesChannel := make(chan *collector.Sample, 100)
@@ -174,12 +311,87 @@ func TestParser_Parse(t *testing.T) {
 			for i, expected := range tc.expectedSamples {
 				require.Equal(t, expected.Trace, samples[i].Trace, "Sample %d trace mismatch", i)
 				require.Equal(t, expected.Tags, samples[i].Tags, "Sample %d tags mismatch", i)
+				require.Equal(t, expected.Profile, samples[i].Profile, "Sample %d profile mismatch", i)
+				require.Equal(t, expected.Value, samples[i].Value, "Sample %d value mismatch", i)
+				require.Equal(t, expected.App, samples[i].App, "Sample %d app mismatch", i)
 				require.NotZero(t, samples[i].Time) // parser should set time
 			}
 		})
 	}
 }
 
+// TestParser_ParseWithStackTable tests that stacks are interned instead of folded
+func TestParser_ParseWithStackTable(t *testing.T) {
+	tables := stack.NewTables(stack.DefaultMaxStacks)
+	parser := phpspy.NewParser(nil, nil, false, false, phpspy.WithStackTables(tables))
+
+	scanner := newScannerFromInput([]string{
+		"0 func1 /app/some/helper.php:10\n1 main /app/test.php:1",
+		"0 func1 /app/some/helper.php:10\n1 main /app/test.php:1",
+	})
+	samplesChannel := make(chan *collector.Sample, 100)
+	parser.Parse(context.Background(), scanner, samplesChannel)
+	close(samplesChannel)
+
+	var samples []*collector.Sample
+	for sample := range samplesChannel {
+		samples = append(samples, sample)
+	}
+
+	require.Len(t, samples, 2)
+	require.Empty(t, samples[0].Trace)
+	require.Same(t, tables.Current(), samples[0].Table)
+	require.Equal(t, "main;func1", samples[0].Table.Folded(samples[0].Stack))
+	require.Equal(t, samples[0].Stack, samples[1].Stack)
+}
+
+// TestParser_ParseWithLongLines tests that lines longer than the bufio.Scanner default limit are parsed
+// and lines longer than the max line size are dropped without stopping the parsing
+func TestParser_ParseWithLongLines(t *testing.T) {
+	input := []string{
+		"0 func1 /app/some/helper.php:10\n1 main /app/test.php:1\n# glopeek _SERVER.REQUEST_URI = /" +
+			strings.Repeat("a", 100*1024),
+		"0 func2 /app/some/helper.php:20\n1 main /app/test.php:1",
+		"0 func3 /app/some/helper.php:30\n1 Helper::" + strings.Repeat("b", 100*1024) + " /app/some/helper.php:5\n" +
+			"2 main /app/test.php:1",
+		"0 func4 /app/some/helper.php:40\n1 main /app/test.php:1",
+	}
+	tagsMapping := map[string][]tag.DynamicTag{
+		"glopeek _SERVER.REQUEST_URI": {{TagKey: "uri"}},
+	}
+
+	parse := func(options ...phpspy.Option) []*collector.Sample {
+		parser := phpspy.NewParser(nil, tagsMapping, false, false, options...)
+		samplesChannel := make(chan *collector.Sample, 10)
+		parser.Parse(context.Background(), newScannerFromInput(input), samplesChannel)
+		close(samplesChannel)
+
+		var samples []*collector.Sample
+		for sample := range samplesChannel {
+			samples = append(samples, sample)
+		}
+		return samples
+	}
+
+	samples := parse()
+	require.Len(t, samples, 4)
+	require.Equal(t, "main;func1", samples[0].Trace)
+	require.Len(t, samples[0].Tags, len("uri=/")+100*1024)
+	require.Equal(t, "main;func2", samples[1].Trace)
+	require.Equal(t, "main;Helper::"+strings.Repeat("b", 100*1024)+";func3", samples[2].Trace)
+	require.Equal(t, "main;func4", samples[3].Trace)
+
+	// traces with an oversized metadata or frame line are dropped instead of being sent without tags
+	// or with a fake stack, the following traces are kept
+	for _, maxLineSize := range []int{64 * 1024, 100 * 1024} {
+		samples = parse(phpspy.WithMaxLineSize(maxLineSize))
+		require.Len(t, samples, 2, "max line size %d", maxLineSize)
+		require.Equal(t, "main;func2", samples[0].Trace)
+		require.Empty(t, samples[0].Tags)
+		require.Equal(t, "main;func4", samples[1].Trace)
+	}
+}
+
 // TestParser_ParseWithContextCancellation tests that parser stops processing when context is cancelled
 func TestParser_ParseWithContextCancellation(t *testing.T) {
 	tc := parserTestCase{
@@ -244,3 +456,46 @@ type errorReader struct{}
 func (e *errorReader) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("simulated read error")
 }
+
+// benchmarkOutput returns phpspy output of traceCount traces with depth frames and a metadata line each.
+func benchmarkOutput(traceCount, depth int) []byte {
+	var output strings.Builder
+	for i := 0; i < traceCount; i++ {
+		for frame := 0; frame < depth-1; frame++ {
+			fmt.Fprintf(&output, "%d App\\Service%d::handle /app/src/Service%d.php:%d\n", frame, frame, frame, frame+10)
+		}
+		fmt.Fprintf(&output, "%d main /app/public/index.php:1\n", depth-1)
+		fmt.Fprintf(&output, "# glopeek _SERVER.REQUEST_URI = /api/users/%d\n\n", i%10)
+	}
+	return []byte(output.String())
+}
+
+func BenchmarkParser_Parse(b *testing.B) {
+	const traceCount = 100
+	output := benchmarkOutput(traceCount, 20)
+	parser := phpspy.NewParser(nil, nil, false, false, phpspy.WithStackTables(stack.NewTables(stack.DefaultMaxStacks)))
+
+	// the trace level logs every processed trace
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	samplesChannel := make(chan *collector.Sample, traceCount)
+	done := make(chan struct{})
+	go func() {
+		for range samplesChannel {
+		}
+		close(done)
+	}()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(output)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.Parse(context.Background(), bufio.NewScanner(bytes.NewReader(output)), samplesChannel)
+	}
+	b.StopTimer()
+
+	close(samplesChannel)
+	<-done
+}
@@ -0,0 +1,47 @@
+package phpspy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	poolResolverCacheSize = 1000
+	fpmPoolTitlePrefix    = "php-fpm: pool "
+)
+
+// PoolResolver resolves php-fpm pool names by worker pid using process titles from procfs.
+type PoolResolver struct {
+	procPath string
+	cache    *procCache
+}
+
+// NewPoolResolver creates a PoolResolver reading process titles from procPath (usually /proc).
+func NewPoolResolver(procPath string) *PoolResolver {
+	return &PoolResolver{
+		procPath: procPath,
+		cache:    newProcCache(poolResolverCacheSize),
+	}
+}
+
+// Pool returns the php-fpm pool name of the worker with the given pid.
+// It returns an empty string if the process isn't a php-fpm worker.
+func (resolver *PoolResolver) Pool(pid string) string {
+	return resolver.cache.get(pid, resolver.resolve)
+}
+
+// resolve reads the pool name from the process title of the worker.
+func (resolver *PoolResolver) resolve(pid string) string {
+	// php-fpm sets the process title to "php-fpm: pool <name>", the title is terminated by NUL bytes
+	cmdline, err := os.ReadFile(filepath.Join(resolver.procPath, pid, "cmdline"))
+	if err != nil {
+		return ""
+	}
+	title := strings.TrimSpace(string(bytes.TrimRight(cmdline, "\x00")))
+	if !strings.HasPrefix(title, fpmPoolTitlePrefix) {
+		return ""
+	}
+	return strings.TrimSpace(title[len(fpmPoolTitlePrefix):])
+}
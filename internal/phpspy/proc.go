@@ -0,0 +1,72 @@
+package phpspy
+
+import (
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// procCacheTTL is how long process info resolved by pid is cached. Pids of exited processes are reused,
+// short-lived php-fpm workers and containers would be tagged with a dead process info if it never expired.
+const procCacheTTL = 10 * time.Second
+
+// procCache caches values resolved from procfs by pid for procCacheTTL.
+type procCache struct {
+	cache *lru.Cache
+	now   func() time.Time
+}
+
+// procEntry is a cached value with its expiration time.
+type procEntry struct {
+	value   string
+	expires time.Time
+}
+
+func newProcCache(size int) *procCache {
+	cache, err := lru.New(size)
+	if err != nil {
+		panic("failed to create LRU cache: " + err.Error())
+	}
+
+	return &procCache{
+		cache: cache,
+		now:   time.Now,
+	}
+}
+
+// get returns the cached value of the pid, the value is resolved again once it has expired.
+// A pid that isn't a number is never resolved, so trace metadata can't make resolvers read other paths.
+func (procs *procCache) get(pid string, resolve func(pid string) string) string {
+	if !isPid(pid) {
+		return ""
+	}
+
+	now := procs.now()
+	if cached, found := procs.cache.Get(pid); found {
+		if entry := cached.(procEntry); now.Before(entry.expires) {
+			return entry.value
+		}
+	}
+
+	value := resolve(pid)
+
+	// a failed lookup is cached too, the process may have already exited
+	// the pid is a substring of the trace metadata, which shouldn't be kept alive
+	procs.cache.Add(strings.Clone(pid), procEntry{value: value, expires: now.Add(procCacheTTL)})
+
+	return value
+}
+
+// isPid reports whether the value is a decimal process id.
+func isPid(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package phpspy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcCache_Get(t *testing.T) {
+	procs := newProcCache(10)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	procs.now = func() time.Time { return now }
+
+	resolved := 0
+	value := "www"
+	resolve := func(pid string) string {
+		resolved++
+		return value
+	}
+
+	assert.Equal(t, "www", procs.get("101", resolve))
+	value = "api"
+	now = now.Add(procCacheTTL - time.Second)
+	assert.Equal(t, "www", procs.get("101", resolve), "the value must be cached")
+	assert.Equal(t, 1, resolved)
+
+	// the pid may belong to another process by now
+	now = now.Add(time.Second)
+	assert.Equal(t, "api", procs.get("101", resolve), "an expired value must be resolved again")
+	assert.Equal(t, 2, resolved)
+
+	for _, pid := range []string{"", "../101", "101/../../etc", "self", "-1"} {
+		assert.Empty(t, procs.get(pid, resolve), "pid %q", pid)
+	}
+	assert.Equal(t, 2, resolved, "invalid pids must not be resolved")
+}
+
+func TestPoolResolver_PidReuse(t *testing.T) {
+	procPath := t.TempDir()
+	writeCmdline := func(title string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(procPath, "101"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procPath, "101", "cmdline"), []byte(title+"\x00\x00"), 0o644))
+	}
+
+	resolver := NewPoolResolver(procPath)
+	now := time.Now()
+	resolver.cache.now = func() time.Time { return now }
+
+	writeCmdline("php-fpm: pool www")
+	assert.Equal(t, "www", resolver.Pool("101"))
+
+	// the worker exits and its pid is reused by a worker of another pool
+	writeCmdline("php-fpm: pool api")
+	assert.Equal(t, "www", resolver.Pool("101"))
+	now = now.Add(procCacheTTL)
+	assert.Equal(t, "api", resolver.Pool("101"))
+}
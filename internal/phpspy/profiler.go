@@ -5,7 +5,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/hakastein/gospy/internal/args"
 	"github.com/rs/zerolog/log"
 	"os/exec"
 	"strings"
@@ -16,18 +15,47 @@ import (
 type Profiler struct {
 	executable string
 	args       []string
+	options    *Options
 	cmd        *exec.Cmd
+	output     *outputFollower
+	exited     chan struct{}
+	exitError  error
+	// outputFifo creates a named pipe at the -o path if it doesn't exist
+	outputFifo bool
 	mu         sync.Mutex
 }
 
+// ProfilerOption configures a Profiler.
+type ProfilerOption func(profiler *Profiler)
+
+// WithOutputFifo creates a named pipe at the -o path if it doesn't exist, so phpspy output never touches the disk.
+// Without it gospy waits for phpspy to create the output file.
+func WithOutputFifo() ProfilerOption {
+	return func(profiler *Profiler) {
+		profiler.outputFifo = true
+	}
+}
+
+// NewProfiler parses phpspy arguments and creates a Profiler.
 func NewProfiler(
 	executable string,
 	args []string,
-) *Profiler {
-	return &Profiler{
+	options ...ProfilerOption,
+) (*Profiler, error) {
+	parsedOptions, err := ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	profiler := &Profiler{
 		executable: executable,
 		args:       args,
+		options:    parsedOptions,
 	}
+	for _, option := range options {
+		option(profiler)
+	}
+	return profiler, nil
 }
 
 func (profiler *Profiler) Start(ctx context.Context) (*bufio.Scanner, error) {
@@ -36,6 +64,10 @@ func (profiler *Profiler) Start(ctx context.Context) (*bufio.Scanner, error) {
 
 	cmd := exec.CommandContext(ctx, profiler.executable, profiler.args...)
 
+	if !profiler.options.WritesToStdout() {
+		return profiler.startWithOutput(cmd)
+	}
+
 	stdout, pipeError := cmd.StdoutPipe()
 	if pipeError != nil {
 		return nil, fmt.Errorf("stdout pipe error: %w", pipeError)
@@ -50,6 +82,30 @@ func (profiler *Profiler) Start(ctx context.Context) (*bufio.Scanner, error) {
 	return scanner, nil
 }
 
+// startWithOutput starts phpspy writing to a file or a named pipe set by -o and follows the output.
+func (profiler *Profiler) startWithOutput(cmd *exec.Cmd) (*bufio.Scanner, error) {
+	output, outputError := openOutput(profiler.options.Output, profiler.outputFifo)
+	if outputError != nil {
+		return nil, fmt.Errorf("output error: %w", outputError)
+	}
+
+	if startError := cmd.Start(); startError != nil {
+		_ = output.Close()
+		return nil, startError
+	}
+
+	// the output doesn't tell when phpspy exits, so the process is awaited right away
+	exited := make(chan struct{})
+	go func() {
+		profiler.exitError = cmd.Wait()
+		close(exited)
+	}()
+	output.follow(exited)
+
+	profiler.cmd, profiler.output, profiler.exited = cmd, output, exited
+	return bufio.NewScanner(output), nil
+}
+
 func (profiler *Profiler) Wait() error {
 	profiler.mu.Lock()
 	defer profiler.mu.Unlock()
@@ -58,41 +114,65 @@ func (profiler *Profiler) Wait() error {
 		return errors.New("no command to wait for")
 	}
 
+	if profiler.output != nil {
+		<-profiler.exited
+		if closeError := profiler.output.Close(); closeError != nil {
+			log.Warn().Err(closeError).Msg("failed to close profiler output")
+		}
+		profiler.output = nil
+		return profiler.exitError
+	}
+
 	return profiler.cmd.Wait()
 }
 
 func (profiler *Profiler) IsConfigurationValid() (bool, error) {
+	options := profiler.options
+
 	unsupportedFlags := []struct {
+		enabled  bool
 		longKey  string
 		shortKey string
 	}{
-		{"version", "v"},
-		{"top", "t"},
-		{"help", "h"},
-		{"single-line", "1"},
-	}
-	for _, keys := range unsupportedFlags {
-		if args.ExtractFlagValue[bool](profiler.args, keys.longKey, keys.shortKey, false) {
-			return false, fmt.Errorf("flag -%s/--%s is unsupported by gospy", keys.shortKey, keys.longKey)
+		{options.Version, "version", "v"},
+		{options.Top, "top", "t"},
+		{options.Help, "help", "h"},
+	}
+	for _, flag := range unsupportedFlags {
+		if flag.enabled {
+			return false, fmt.Errorf("flag -%s/--%s is unsupported by gospy", flag.shortKey, flag.longKey)
 		}
 	}
 
-	output := args.ExtractFlagValue[string](profiler.args, "output", "o", "stdout")
-	if output != "stdout" && output != "-" {
-		return false, errors.New("output must be set to stdout")
+	if options.EventHandler != "" && options.EventHandler != "fout" {
+		return false, fmt.Errorf("event handler %s is unsupported by gospy", options.EventHandler)
+	}
+
+	if options.PID == 0 && options.Pgrep == "" && len(options.Command) == 0 {
+		return false, errors.New("phpspy requires -p, -P or a command to profile")
 	}
 
-	pgrepMode := args.ExtractFlagValue[string](profiler.args, "pgrep", "P", "")
-	if pgrepMode != "" {
-		bufferSize := args.ExtractFlagValue[int](profiler.args, "buffer-size", "b", 4096)
-		eventHandlerOpts := args.ExtractFlagValue[string](profiler.args, "event-handler-opts", "J", "")
-		if bufferSize > 4096 && !strings.Contains(eventHandlerOpts, "m") {
+	if options.Pgrep != "" {
+		if options.BufferSize > defaultBufferSize && !strings.Contains(options.EventHandlerOpts, "m") {
 			log.Warn().Msg("using large buffer size without mutex; consider adding -J m with -b > 4096")
 		}
 	}
 	return true, nil
 }
 
+// Options returns the parsed phpspy arguments.
+func (profiler *Profiler) Options() *Options {
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+
+	return profiler.options
+}
+
 func (profiler *Profiler) GetHZ() int {
-	return args.ExtractFlagValue[int](profiler.args, "rate-hz", "H", 99)
+	return profiler.Options().RateHz()
+}
+
+// GetPID returns pid of the profiled process, or 0 if the profiler isn't attached to a single process.
+func (profiler *Profiler) GetPID() int {
+	return profiler.Options().PID
 }
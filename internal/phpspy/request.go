@@ -0,0 +1,80 @@
+package phpspy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hakastein/gospy/internal/tag"
+)
+
+// RequestTag is a built-in tag filled from phpspy request info or a peeked global variable.
+type RequestTag struct {
+	// Name is used both as the tag key and as the gospy flag suffix (--tag-request-<name>)
+	Name string
+	// MetaKey is the metadata key phpspy writes the value with
+	MetaKey   string
+	Normalize func(string) string
+}
+
+// RequestTags lists built-in request tags supported by gospy.
+// phpspy request info (-r) has no request method, so the method is peeked from $_SERVER instead.
+var RequestTags = []RequestTag{
+	{Name: "uri", MetaKey: "uri", Normalize: normalizeURI},
+	{Name: "method", MetaKey: "glopeek server.REQUEST_METHOD", Normalize: strings.ToUpper},
+	{Name: "query", MetaKey: "qstring"},
+	{Name: "cookie", MetaKey: "cookie"},
+	{Name: "path", MetaKey: "path"},
+}
+
+// AddRequestTags adds mapping of the enabled request tags to tagsMapping.
+// phpspy flags required by the tags are handled by Profiler.EnsureMetaSources as for any dynamic tag.
+func AddRequestTags(names []string, tagsMapping map[string][]tag.DynamicTag) error {
+	for _, name := range names {
+		requestTag, found := findRequestTag(name)
+		if !found {
+			return fmt.Errorf("unknown request tag `%s`", name)
+		}
+
+		if isTagKeyMapped(tagsMapping, requestTag.Name) {
+			return fmt.Errorf("tag `%s` is already defined", requestTag.Name)
+		}
+
+		tagsMapping[requestTag.MetaKey] = append(tagsMapping[requestTag.MetaKey], tag.DynamicTag{
+			TagKey:       requestTag.Name,
+			TagNormalize: requestTag.Normalize,
+		})
+	}
+
+	return nil
+}
+
+func findRequestTag(name string) (RequestTag, bool) {
+	for _, requestTag := range RequestTags {
+		if requestTag.Name == name {
+			return requestTag, true
+		}
+	}
+	return RequestTag{}, false
+}
+
+func isTagKeyMapped(tagsMapping map[string][]tag.DynamicTag, tagKey string) bool {
+	for _, dynamicTags := range tagsMapping {
+		for _, dynamicTag := range dynamicTags {
+			if dynamicTag.TagKey == tagKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeURI removes query string and fragment from request uri.
+func normalizeURI(uri string) string {
+	if idx := strings.IndexAny(uri, "?#"); idx != -1 {
+		uri = uri[:idx]
+	}
+	if uri == "" {
+		return "/"
+	}
+	return uri
+}
@@ -0,0 +1,49 @@
+package phpspy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/tag"
+	"github.com/hakastein/gospy/internal/transform"
+)
+
+func TestAddRequestTags(t *testing.T) {
+	t.Run("maps and normalizes tags", func(t *testing.T) {
+		tagsMapping := map[string][]tag.DynamicTag{}
+
+		err := AddRequestTags([]string{"uri", "method", "query", "cookie"}, tagsMapping)
+		require.NoError(t, err)
+
+		assert.Equal(t, "cookie=theme=dark,method=POST,query=a=1&b=2,uri=/api/users",
+			transform.MetaToTags([]string{
+				"# uri = /api/users?a=1&b=2",
+				"# qstring = a=1&b=2",
+				"# cookie = theme=dark",
+				"# glopeek server.REQUEST_METHOD = post",
+			}, tagsMapping),
+		)
+	})
+
+	t.Run("conflicts with user tag", func(t *testing.T) {
+		tagsMapping := map[string][]tag.DynamicTag{
+			"glopeek server.REQUEST_URI": {{TagKey: "uri"}},
+		}
+
+		err := AddRequestTags([]string{"uri"}, tagsMapping)
+		assert.EqualError(t, err, "tag `uri` is already defined")
+	})
+
+	t.Run("unknown tag", func(t *testing.T) {
+		err := AddRequestTags([]string{"referer"}, map[string][]tag.DynamicTag{})
+		assert.EqualError(t, err, "unknown request tag `referer`")
+	})
+}
+
+func TestNormalizeURI(t *testing.T) {
+	assert.Equal(t, "/users", normalizeURI("/users?id=1#top"))
+	assert.Equal(t, "/users/", normalizeURI("/users/"))
+	assert.Equal(t, "/", normalizeURI("?id=1"))
+}
@@ -0,0 +1,130 @@
+package phpspy
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	glopeekMetaPrefix = "glopeek "
+	varpeekMetaPrefix = "varpeek "
+)
+
+// requestInfoOptions maps request info metadata keys to phpspy -r/--request-info option letters.
+var requestInfoOptions = map[string]string{
+	"uri":     "u",
+	"qstring": "q",
+	"path":    "p",
+	"cookie":  "c",
+}
+
+// verboseFieldOptions maps metadata keys to phpspy -d/--verbose-fields option letters.
+var verboseFieldOptions = map[string]string{
+	"pid": "p",
+	"ts":  "t",
+}
+
+// EnsureMetaSources checks that phpspy is configured to emit metadata for every given dynamic tag key.
+// Missing flags are added to the profiler arguments if inject is true, otherwise an error is returned.
+func (profiler *Profiler) EnsureMetaSources(keys []string, inject bool) error {
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+
+	missing := missingMetaFlags(profiler.options, keys)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if !inject {
+		return fmt.Errorf("dynamic tags require phpspy flags: %s", strings.Join(missing, " "))
+	}
+
+	log.Warn().Strs("flags", missing).Msg("phpspy command lacks flags required by dynamic tags, adding them")
+	// flags after `--` or the command would be passed to the profiled command, so they're inserted before it
+	_, optionsEnd, err := parseArgs(profiler.args)
+	if err != nil {
+		return err
+	}
+	arguments := slices.Insert(slices.Clone(profiler.args), optionsEnd, missing...)
+	options, err := ParseArgs(arguments)
+	if err != nil {
+		return err
+	}
+	if stillMissing := missingMetaFlags(options, keys); len(stillMissing) > 0 {
+		return fmt.Errorf("failed to add phpspy flags required by dynamic tags: %s", strings.Join(stillMissing, " "))
+	}
+	profiler.args, profiler.options = arguments, options
+
+	return nil
+}
+
+// missingMetaFlags returns phpspy flags which have to be added to emit metadata with the given keys.
+func missingMetaFlags(options *Options, keys []string) []string {
+	var (
+		missing     []string
+		peekGlobals = slices.Clone(options.PeekGlobals)
+		peekVars    = slices.Clone(options.PeekVars)
+		needRequest string
+		needVerbose string
+		sortedKeys  = slices.Clone(keys)
+	)
+
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		switch {
+		case strings.HasPrefix(key, glopeekMetaPrefix):
+			spec := strings.TrimPrefix(key, glopeekMetaPrefix)
+			if !slices.Contains(peekGlobals, spec) {
+				missing = append(missing, "--peek-global="+spec)
+				peekGlobals = append(peekGlobals, spec)
+			}
+		case strings.HasPrefix(key, varpeekMetaPrefix):
+			spec := strings.TrimPrefix(key, varpeekMetaPrefix)
+			if !slices.Contains(peekVars, spec) {
+				missing = append(missing, "--peek-var="+spec)
+				peekVars = append(peekVars, spec)
+			}
+		case requestInfoOptions[key] != "":
+			needRequest = addOptionLetter(needRequest, options.RequestInfo, requestInfoOptions[key])
+		case verboseFieldOptions[key] != "":
+			needVerbose = addOptionLetter(needVerbose, options.VerboseFields, verboseFieldOptions[key])
+		default:
+			log.Warn().Str("key", key).Msg("dynamic tag key isn't emitted by phpspy flags known to gospy")
+		}
+	}
+
+	// phpspy applies the last occurrence of an option, so appended values keep already enabled letters
+	if needRequest != "" {
+		missing = append(missing, "--request-info="+mergeOptionLetters(options.RequestInfo, needRequest))
+	}
+	if needVerbose != "" {
+		missing = append(missing, "--verbose-fields="+mergeOptionLetters(options.VerboseFields, needVerbose))
+	}
+
+	return missing
+}
+
+// addOptionLetter adds letter to the needed letters if it's not enabled in the current option value.
+func addOptionLetter(needed, current, letter string) string {
+	if strings.Contains(current, letter) || strings.Contains(needed, letter) {
+		return needed
+	}
+	return needed + letter
+}
+
+// mergeOptionLetters enables letters in phpspy option value, dropping their negated (capital) form.
+func mergeOptionLetters(current, enable string) string {
+	var merged strings.Builder
+	for _, letter := range current {
+		if !strings.ContainsRune(enable, letter+'a'-'A') {
+			merged.WriteRune(letter)
+		}
+	}
+	merged.WriteString(enable)
+	return merged.String()
+}
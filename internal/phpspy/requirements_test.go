@@ -0,0 +1,121 @@
+package phpspy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiler_EnsureMetaSources(t *testing.T) {
+	tests := []struct {
+		name         string
+		arguments    []string
+		keys         []string
+		expectedArgs []string
+	}{
+		{
+			name:         "all flags present",
+			arguments:    []string{"-g", "server.REQUEST_URI", "--peek-var=user@/app/a.php:10", "-r", "u", "-dp"},
+			keys:         []string{"glopeek server.REQUEST_URI", "varpeek user@/app/a.php:10", "uri", "pid"},
+			expectedArgs: []string{"-g", "server.REQUEST_URI", "--peek-var=user@/app/a.php:10", "-r", "u", "-dp"},
+		},
+		{
+			name:      "missing peek flags",
+			arguments: []string{"-P", "php-fpm", "-g", "server.REQUEST_URI"},
+			keys:      []string{"varpeek user@/app/a.php:10", "glopeek server.REQUEST_METHOD", "glopeek server.REQUEST_URI"},
+			expectedArgs: []string{
+				"-P", "php-fpm", "-g", "server.REQUEST_URI",
+				"--peek-global=server.REQUEST_METHOD",
+				"--peek-var=user@/app/a.php:10",
+			},
+		},
+		{
+			name:         "missing request info and verbose fields",
+			arguments:    []string{"-H", "25"},
+			keys:         []string{"uri", "qstring", "pid"},
+			expectedArgs: []string{"-H", "25", "--request-info=qu", "--verbose-fields=p"},
+		},
+		{
+			name:         "flags inserted before the command separator",
+			arguments:    []string{"-H", "25", "--", "php", "script.php"},
+			keys:         []string{"glopeek server.REQUEST_URI", "pid"},
+			expectedArgs: []string{"-H", "25", "--peek-global=server.REQUEST_URI", "--verbose-fields=p", "--", "php", "script.php"},
+		},
+		{
+			name:         "flags inserted before the command",
+			arguments:    []string{"-H", "25", "php", "-f", "script.php"},
+			keys:         []string{"uri"},
+			expectedArgs: []string{"-H", "25", "--request-info=u", "php", "-f", "script.php"},
+		},
+		{
+			name:         "request info merged with the last -r option",
+			arguments:    []string{"-r", "p", "--request-info=QCUp"},
+			keys:         []string{"uri", "path"},
+			expectedArgs: []string{"-r", "p", "--request-info=QCUp", "--request-info=QCpu"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiler, err := NewProfiler("phpspy", tt.arguments)
+			require.NoError(t, err)
+
+			err = profiler.EnsureMetaSources(tt.keys, true)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedArgs, profiler.args)
+		})
+	}
+
+	t.Run("injected flags are parsed", func(t *testing.T) {
+		profiler, err := NewProfiler("phpspy", []string{"-r", "QCUp"})
+		require.NoError(t, err)
+
+		err = profiler.EnsureMetaSources([]string{"uri"}, true)
+
+		require.NoError(t, err)
+		assert.Equal(t, "QCpu", profiler.Options().RequestInfo)
+	})
+
+	t.Run("injected flags are parsed in command mode", func(t *testing.T) {
+		profiler, err := NewProfiler("phpspy", []string{"-H", "25", "--", "php", "script.php"})
+		require.NoError(t, err)
+
+		err = profiler.EnsureMetaSources([]string{"glopeek server.REQUEST_URI"}, true)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"server.REQUEST_URI"}, profiler.Options().PeekGlobals)
+		assert.Equal(t, []string{"php", "script.php"}, profiler.Options().Command)
+	})
+
+	t.Run("warns about added request info", func(t *testing.T) {
+		var output bytes.Buffer
+		logger := log.Logger
+		log.Logger = zerolog.New(&output)
+		defer func() { log.Logger = logger }()
+
+		profiler, err := NewProfiler("phpspy", []string{"-H", "25"})
+		require.NoError(t, err)
+
+		err = profiler.EnsureMetaSources([]string{"uri"}, true)
+
+		require.NoError(t, err)
+		assert.Equal(t, "u", profiler.Options().RequestInfo)
+		assert.Contains(t, output.String(), `"level":"warn"`)
+		assert.Contains(t, output.String(), `"flags":["--request-info=u"]`)
+	})
+
+	t.Run("fails on missing flags without injection", func(t *testing.T) {
+		profiler, err := NewProfiler("phpspy", []string{"-r", "q"})
+		require.NoError(t, err)
+
+		err = profiler.EnsureMetaSources([]string{"glopeek server.REQUEST_URI", "uri", "qstring"}, false)
+
+		assert.EqualError(t, err, "dynamic tags require phpspy flags: --peek-global=server.REQUEST_URI --request-info=qu")
+		assert.Equal(t, []string{"-r", "q"}, profiler.args)
+	})
+}
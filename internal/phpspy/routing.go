@@ -0,0 +1,138 @@
+package phpspy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/hakastein/gospy/internal/validator"
+)
+
+// AppRouteKind is what an AppRoute matches samples by.
+type AppRouteKind int
+
+const (
+	// RouteEntryPoint matches entry points by an entry point pattern.
+	RouteEntryPoint AppRouteKind = iota
+	// RoutePrefix matches entry points starting with a path prefix.
+	RoutePrefix
+	// RouteTag matches values of a dynamic tag by a doublestar glob.
+	RouteTag
+)
+
+// AppRoute sends samples it matches to the App Pyroscope application instead of the default one.
+type AppRoute struct {
+	Kind AppRouteKind
+	// Pattern is the entry point pattern of a RouteEntryPoint route.
+	Pattern validator.Pattern
+	// Prefix is the path prefix of a RoutePrefix route.
+	Prefix string
+	// Tag and Glob are the tag key and value glob of a RouteTag route.
+	Tag  string
+	Glob string
+	App  string
+}
+
+// ParseAppRoute parses a route in `entrypoint:pattern=app`, `prefix:path=app` or `tag:key:glob=app` format.
+// Entry point patterns have the same format as --entrypoint patterns without the `!` prefix.
+func ParseAppRoute(input string) (AppRoute, error) {
+	kind, rest, found := strings.Cut(input, ":")
+	idx := strings.LastIndex(rest, "=")
+	if !found || idx <= 0 {
+		return AppRoute{}, fmt.Errorf("invalid app route `%s`, expected format is kind:pattern=app", input)
+	}
+
+	route := AppRoute{App: rest[idx+1:]}
+	if route.App == "" || strings.ContainsAny(route.App, "{},") {
+		return AppRoute{}, fmt.Errorf("invalid app name in app route `%s`", input)
+	}
+
+	match := rest[:idx]
+	switch kind {
+	case "entrypoint":
+		pattern, err := validator.ParsePattern(match)
+		if err != nil {
+			return AppRoute{}, fmt.Errorf("invalid pattern in app route `%s`: %v", input, err)
+		}
+		route.Kind, route.Pattern = RouteEntryPoint, pattern
+	case "prefix":
+		route.Kind, route.Prefix = RoutePrefix, match
+	case "tag":
+		tag, glob, found := strings.Cut(match, ":")
+		if !found || tag == "" || glob == "" {
+			return AppRoute{}, fmt.Errorf("invalid tag app route `%s`, expected format is tag:key:glob=app", input)
+		}
+		if !doublestar.ValidatePattern(glob) {
+			return AppRoute{}, fmt.Errorf("invalid glob in app route `%s`", input)
+		}
+		route.Kind, route.Tag, route.Glob = RouteTag, tag, glob
+	default:
+		return AppRoute{}, fmt.Errorf("unknown app route kind `%s`", kind)
+	}
+
+	return route, nil
+}
+
+// ParseAppRoutes parses each input with ParseAppRoute.
+func ParseAppRoutes(inputs []string) ([]AppRoute, error) {
+	routes := make([]AppRoute, 0, len(inputs))
+	for _, input := range inputs {
+		route, err := ParseAppRoute(input)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Matches checks if a sample of the entry point with the tags in `key=value,key=value` format matches the route.
+func (route AppRoute) Matches(entryPoint, tags string) bool {
+	switch route.Kind {
+	case RouteEntryPoint:
+		return route.Pattern.Matches(entryPoint)
+	case RoutePrefix:
+		return strings.HasPrefix(entryPoint, route.Prefix)
+	case RouteTag:
+		value, found := tagValue(tags, route.Tag)
+		if !found {
+			return false
+		}
+		match, err := doublestar.Match(route.Glob, value)
+		return err == nil && match
+	}
+	return false
+}
+
+// AppRouter picks the Pyroscope application of samples, the first matching route wins.
+type AppRouter struct {
+	routes []AppRoute
+}
+
+// NewAppRouter creates an AppRouter with the given routes.
+func NewAppRouter(routes []AppRoute) *AppRouter {
+	return &AppRouter{routes: routes}
+}
+
+// Route returns the application of a sample, or an empty string if no route matches and the default one is used.
+func (router *AppRouter) Route(entryPoint, tags string) string {
+	for _, route := range router.routes {
+		if route.Matches(entryPoint, tags) {
+			return route.App
+		}
+	}
+	return ""
+}
+
+// tagValue returns the value of the key in tags in `key=value,key=value` format.
+func tagValue(tags, key string) (string, bool) {
+	for tags != "" {
+		var pair string
+		pair, tags, _ = strings.Cut(tags, ",")
+		if name, value, found := strings.Cut(pair, "="); found && name == key {
+			return value, true
+		}
+	}
+	return "", false
+}
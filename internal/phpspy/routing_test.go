@@ -0,0 +1,68 @@
+package phpspy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/phpspy"
+)
+
+func TestParseAppRoute(t *testing.T) {
+	route, err := phpspy.ParseAppRoute("entrypoint:/var/www/shop/**/*.php=shop")
+	require.NoError(t, err)
+	assert.Equal(t, phpspy.RouteEntryPoint, route.Kind)
+	assert.Equal(t, "shop", route.App)
+
+	route, err = phpspy.ParseAppRoute("prefix:/var/www/blog/=blog")
+	require.NoError(t, err)
+	assert.Equal(t, phpspy.AppRoute{Kind: phpspy.RoutePrefix, Prefix: "/var/www/blog/", App: "blog"}, route)
+
+	route, err = phpspy.ParseAppRoute("tag:host:*.example.com=example")
+	require.NoError(t, err)
+	assert.Equal(t, phpspy.AppRoute{Kind: phpspy.RouteTag, Tag: "host", Glob: "*.example.com", App: "example"}, route)
+
+	for input, wantErr := range map[string]string{
+		"shop":                 "invalid app route `shop`, expected format is kind:pattern=app",
+		"prefix:=blog":         "invalid app route `prefix:=blog`, expected format is kind:pattern=app",
+		"prefix:/var/www/=":    "invalid app name in app route `prefix:/var/www/=`",
+		"prefix:/var/www/=a{}": "invalid app name in app route `prefix:/var/www/=a{}`",
+		"vhost:shop=shop":      "unknown app route kind `vhost`",
+		"tag:host=shop":        "invalid tag app route `tag:host=shop`, expected format is tag:key:glob=app",
+		"tag:host:[a=shop":     "invalid glob in app route `tag:host:[a=shop`",
+		"entrypoint:re:(=shop": "invalid pattern in app route `entrypoint:re:(=shop`: error parsing regexp: missing closing ): `(`",
+	} {
+		_, err := phpspy.ParseAppRoute(input)
+		assert.EqualError(t, err, wantErr)
+	}
+}
+
+func TestAppRouter_Route(t *testing.T) {
+	routes, err := phpspy.ParseAppRoutes([]string{
+		"tag:host:*.example.com=example",
+		"prefix:/var/www/blog/=blog",
+		"entrypoint:re:^/var/www/(shop|store)/=shop",
+	})
+	require.NoError(t, err)
+	router := phpspy.NewAppRouter(routes)
+
+	tests := []struct {
+		name       string
+		entryPoint string
+		tags       string
+		want       string
+	}{
+		{name: "tag", entryPoint: "/var/www/blog/index.php", tags: "uri=/,host=www.example.com", want: "example"},
+		{name: "prefix", entryPoint: "/var/www/blog/index.php", tags: "host=blog.local", want: "blog"},
+		{name: "entrypoint", entryPoint: "/var/www/store/index.php", want: "shop"},
+		{name: "tag key must match", entryPoint: "/srv/index.php", tags: "server=www.example.com"},
+		{name: "no match", entryPoint: "/srv/index.php", tags: "host=other.local"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, router.Route(tt.entryPoint, tt.tags))
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package phpspy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hakastein/gospy/internal/validator"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const samplerCacheSize = 1000
+
+// noSamplingRule is cached for entry points no sampling rule matches.
+const noSamplingRule = -1
+
+// SamplingRule keeps one of every Ratio samples of entry points matching the pattern.
+type SamplingRule struct {
+	Pattern validator.Pattern
+	Ratio   int
+}
+
+// ParseSamplingRule parses a rule in `pattern=ratio` format, pattern is an entry point pattern
+// without the exclude prefix, ratio is a positive integer.
+func ParseSamplingRule(input string) (SamplingRule, error) {
+	idx := strings.LastIndex(input, "=")
+	if idx <= 0 {
+		return SamplingRule{}, fmt.Errorf("invalid sampling rule `%s`, expected format is pattern=ratio", input)
+	}
+
+	ratio, err := strconv.Atoi(input[idx+1:])
+	if err != nil || ratio < 1 {
+		return SamplingRule{}, fmt.Errorf("invalid ratio in sampling rule `%s`, expected a positive integer", input)
+	}
+
+	pattern, err := validator.ParsePattern(input[:idx])
+	if err != nil {
+		return SamplingRule{}, fmt.Errorf("invalid pattern in sampling rule `%s`: %v", input, err)
+	}
+
+	return SamplingRule{Pattern: pattern, Ratio: ratio}, nil
+}
+
+// ParseSamplingRules parses each input with ParseSamplingRule.
+func ParseSamplingRules(inputs []string) ([]SamplingRule, error) {
+	rules := make([]SamplingRule, 0, len(inputs))
+	for _, input := range inputs {
+		rule, err := ParseSamplingRule(input)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Sampler down-samples traces by entry point. It isn't safe for concurrent use.
+type Sampler struct {
+	rules []SamplingRule
+	// seen counts samples per rule modulo its ratio, the first one of each Ratio samples is kept
+	seen []int
+	// cache maps entry points to the index of the first matching rule
+	cache *lru.Cache
+}
+
+// NewSampler creates a Sampler, the first rule matching an entry point is used.
+func NewSampler(rules []SamplingRule) *Sampler {
+	cache, err := lru.New(samplerCacheSize)
+	if err != nil {
+		panic("failed to create LRU cache: " + err.Error())
+	}
+
+	return &Sampler{
+		rules: rules,
+		seen:  make([]int, len(rules)),
+		cache: cache,
+	}
+}
+
+// Sample reports whether a sample of the entry point should be kept and its weight,
+// the number of samples it represents, so totals stay representative.
+func (sampler *Sampler) Sample(entryPoint string) (bool, int) {
+	ruleIndex := sampler.ruleIndex(entryPoint)
+	if ruleIndex == noSamplingRule {
+		return true, 1
+	}
+
+	ratio := sampler.rules[ruleIndex].Ratio
+	keep := sampler.seen[ruleIndex] == 0
+	sampler.seen[ruleIndex] = (sampler.seen[ruleIndex] + 1) % ratio
+
+	return keep, ratio
+}
+
+// ruleIndex returns the index of the first rule matching the entry point, or noSamplingRule.
+func (sampler *Sampler) ruleIndex(entryPoint string) int {
+	if cached, found := sampler.cache.Get(entryPoint); found {
+		return cached.(int)
+	}
+
+	index := noSamplingRule
+	for i, rule := range sampler.rules {
+		if rule.Pattern.Matches(entryPoint) {
+			index = i
+			break
+		}
+	}
+	// the entry point may be a substring of the whole trace, which shouldn't be kept alive
+	sampler.cache.Add(strings.Clone(entryPoint), index)
+
+	return index
+}
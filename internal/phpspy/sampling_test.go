@@ -0,0 +1,57 @@
+package phpspy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/phpspy"
+)
+
+func TestParseSamplingRule(t *testing.T) {
+	rule, err := phpspy.ParseSamplingRule("index.php=10")
+	require.NoError(t, err)
+	assert.Equal(t, 10, rule.Ratio)
+	assert.True(t, rule.Pattern.Matches("/app/public/index.php"))
+
+	rule, err = phpspy.ParseSamplingRule(`re:^/app/(api|web)/=5`)
+	require.NoError(t, err)
+	assert.Equal(t, 5, rule.Ratio)
+	assert.True(t, rule.Pattern.Matches("/app/api/v1.php"))
+
+	for input, wantErr := range map[string]string{
+		"index.php":     "invalid sampling rule `index.php`, expected format is pattern=ratio",
+		"=10":           "invalid sampling rule `=10`, expected format is pattern=ratio",
+		"index.php=0":   "invalid ratio in sampling rule `index.php=0`, expected a positive integer",
+		"index.php=ten": "invalid ratio in sampling rule `index.php=ten`, expected a positive integer",
+		"re:(=2":        "invalid pattern in sampling rule `re:(=2`: error parsing regexp: missing closing ): `(`",
+	} {
+		_, err := phpspy.ParseSamplingRule(input)
+		assert.EqualError(t, err, wantErr)
+	}
+}
+
+func TestSampler_Sample(t *testing.T) {
+	rules, err := phpspy.ParseSamplingRules([]string{"index.php=3", "/app/**/*.php=2"})
+	require.NoError(t, err)
+	sampler := phpspy.NewSampler(rules)
+
+	var kept []bool
+	for i := 0; i < 6; i++ {
+		keep, weight := sampler.Sample("/app/public/index.php")
+		assert.Equal(t, 3, weight)
+		kept = append(kept, keep)
+	}
+	assert.Equal(t, []bool{true, false, false, true, false, false}, kept)
+
+	keep, weight := sampler.Sample("/app/cron/cleanup.php")
+	assert.True(t, keep)
+	assert.Equal(t, 2, weight)
+	keep, _ = sampler.Sample("/app/cron/report.php")
+	assert.False(t, keep, "samples of entry points matching the same rule are counted together")
+
+	keep, weight = sampler.Sample("/srv/other.php")
+	assert.True(t, keep)
+	assert.Equal(t, 1, weight)
+}
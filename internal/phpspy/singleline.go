@@ -0,0 +1,114 @@
+package phpspy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"unicode"
+
+	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/tag"
+)
+
+// metaSeparatorRegexp matches the separator before a metadata entry in single-line output.
+// Metadata values may contain semicolons, so only a semicolon followed by # starts a new entry.
+var metaSeparatorRegexp = regexp.MustCompile(`;\s*#`)
+
+var frameSeparator = []byte(";")
+
+// SingleLineParser parses phpspy output in single-line mode (-1/--single-line).
+// Each line is a complete trace: frames separated by semicolons, leaf frame first, followed by metadata entries.
+type SingleLineParser struct {
+	*Parser
+	// frame is the buffer frames without the depth number are prefixed in
+	frame []byte
+}
+
+// NewSingleLineParser initializes a new SingleLineParser.
+func NewSingleLineParser(
+	entryPoints []string,
+	tagsMapping map[string][]tag.DynamicTag,
+	tagEntrypoint bool,
+	keepEntrypointName bool,
+	options ...Option,
+) *SingleLineParser {
+	return &SingleLineParser{
+		Parser: NewParser(entryPoints, tagsMapping, tagEntrypoint, keepEntrypointName, options...),
+	}
+}
+
+// Parse reads and processes lines from the scanner, converting each of them into a folded stack sample.
+func (parser *SingleLineParser) Parse(
+	ctx context.Context,
+	scanner *bufio.Scanner,
+	foldedStacks chan<- *collector.Sample,
+) {
+	parser.scanOutput(ctx, scanner, func(line []byte) {
+		// a dropped line is a whole trace, it's counted before the next one is parsed
+		parser.discardTrace()
+		if trimmed := bytes.TrimSpace(line); len(trimmed) == 0 {
+			return
+		}
+
+		parser.splitLine(line)
+		parser.processTrace(foldedStacks)
+	})
+}
+
+// splitLine fills the current trace and metadata from a single-line trace.
+func (parser *SingleLineParser) splitLine(line []byte) {
+	frames, meta := line, []byte(nil)
+	if trimmed := bytes.TrimSpace(line); trimmed[0] == '#' {
+		frames, meta = nil, trimmed
+	} else if loc := metaSeparatorRegexp.FindIndex(line); loc != nil {
+		frames, meta = line[:loc[0]], line[loc[1]-1:]
+	}
+
+	depth := 0
+	for len(frames) > 0 {
+		var frame []byte
+		frame, frames, _ = bytes.Cut(frames, frameSeparator)
+		frame = bytes.TrimSpace(frame)
+		if len(frame) == 0 {
+			continue
+		}
+		// frames may be written without the depth number multi-line output starts with
+		if fieldCount(frame) == 2 {
+			parser.frame = append(strconv.AppendInt(parser.frame[:0], int64(depth), 10), ' ')
+			parser.frame = append(parser.frame, frame...)
+			frame = parser.frame
+		}
+		parser.addToTrace(frame)
+		depth++
+	}
+
+	// each metadata entry starts with # the separator ends with
+	for len(meta) > 0 {
+		entry := meta
+		if loc := metaSeparatorRegexp.FindIndex(meta); loc != nil {
+			entry, meta = meta[:loc[0]], meta[loc[1]-1:]
+		} else {
+			meta = nil
+		}
+		parser.addToMeta(bytes.TrimSpace(entry))
+	}
+}
+
+// fieldCount returns the number of whitespace separated fields of the frame.
+func fieldCount(frame []byte) int {
+	count := 0
+	for {
+		frame = bytes.TrimLeftFunc(frame, unicode.IsSpace)
+		if len(frame) == 0 {
+			return count
+		}
+		count++
+		end := bytes.IndexFunc(frame, unicode.IsSpace)
+		if end == -1 {
+			return count
+		}
+		frame = frame[end:]
+	}
+}
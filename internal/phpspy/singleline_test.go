@@ -0,0 +1,119 @@
+package phpspy_test
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/phpspy"
+	"github.com/hakastein/gospy/internal/tag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleLineParser_Parse(t *testing.T) {
+	testCases := []parserTestCase{
+		{
+			name: "frames separated by semicolons",
+			input: []string{
+				"0 func1 /app/some/helper.php:10; 1 main /app/index.php:1",
+				"0 func2 /app/some/helper.php:20;1 main /app/index.php:1",
+			},
+			entryPoints: []string{"/app/index.php"},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: ""},
+				{Trace: "main;func2", Tags: ""},
+			},
+		},
+		{
+			name: "frames without depth",
+			input: []string{
+				"func1 /app/some/helper.php:10;main /app/index.php:1",
+			},
+			entryPoints:        []string{"/app/index.php"},
+			keepEntrypointName: true,
+			expectedSamples: []collector.Sample{
+				{Trace: "main /app/index.php;func1", Tags: ""},
+			},
+		},
+		{
+			name: "entrypoint filtering",
+			input: []string{
+				"0 func1 /app/some/helper.php:10; 1 main /app/allowed.php:1",
+				"0 func2 /app/some/helper.php:20; 1 main /app/blocked.php:1",
+			},
+			entryPoints: []string{"/app/allowed.php"},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: ""},
+			},
+		},
+		{
+			name: "metadata appended to frames",
+			input: []string{
+				"0 func1 /app/some/helper.php:10; 1 main /app/index.php:1; # uri = /api/users?a=1;b=2; # pid = 101",
+			},
+			entryPoints: []string{"/app/index.php"},
+			tagsMapping: map[string][]tag.DynamicTag{
+				"uri": {{TagKey: "uri"}},
+			},
+			options: []phpspy.Option{phpspy.WithPidTag()},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: "uri=/api/users?a=1;b=2,pid=101"},
+			},
+		},
+		{
+			name: "blank and invalid lines are skipped",
+			input: []string{
+				"",
+				"# pid = 101",
+				"0 func1 /app/some/helper.php:10; 1 main /app/index.php:1",
+			},
+			entryPoints: []string{"/app/index.php"},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func1", Tags: ""},
+			},
+		},
+		{
+			name: "traces over the max line size are dropped",
+			input: []string{
+				"0 func1 /app/some/helper.php:10; 1 main /app/index.php:1; # uri = /" + strings.Repeat("a", 1024),
+				"0 func2 /app/some/helper.php:20; 1 main /app/index.php:1",
+			},
+			entryPoints: []string{"/app/index.php"},
+			options:     []phpspy.Option{phpspy.WithMaxLineSize(512)},
+			expectedSamples: []collector.Sample{
+				{Trace: "main;func2", Tags: ""},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := phpspy.NewSingleLineParser(tc.entryPoints, tc.tagsMapping, tc.tagEntrypoint, tc.keepEntrypointName, tc.options...)
+
+			scanner := bufio.NewScanner(strings.NewReader(strings.Join(tc.input, "\n") + "\n"))
+			samplesChannel := make(chan *collector.Sample, 100)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			go func() {
+				parser.Parse(ctx, scanner, samplesChannel)
+				close(samplesChannel)
+			}()
+
+			var samples []*collector.Sample
+			for sample := range samplesChannel {
+				samples = append(samples, sample)
+			}
+
+			require.Len(t, samples, len(tc.expectedSamples))
+			for i, expected := range tc.expectedSamples {
+				require.Equal(t, expected.Trace, samples[i].Trace, "Sample %d trace mismatch", i)
+				require.Equal(t, expected.Tags, samples[i].Tags, "Sample %d tags mismatch", i)
+			}
+		})
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 type Args struct {
 	RateHz int
+	// OutputFifo creates a named pipe at the profiler output path if it doesn't exist
+	OutputFifo bool
 }
 
 // Profiler interface
@@ -18,20 +20,27 @@ type Profiler interface {
 	Wait() error
 	IsConfigurationValid() (bool, error)
 	GetHZ() int
+	GetPID() int
+	EnsureMetaSources(keys []string, inject bool) error
 }
 
 func Init(
 	profilerPath string,
 	profilerArguments []string,
+	args Args,
 ) (Profiler, error) {
-	var profiler Profiler
-
 	switch filepath.Base(profilerPath) {
 	case "phpspy":
-		profiler = phpspy.NewProfiler(profilerPath, profilerArguments)
+		var options []phpspy.ProfilerOption
+		if args.OutputFifo {
+			options = append(options, phpspy.WithOutputFifo())
+		}
+		phpspyProfiler, err := phpspy.NewProfiler(profilerPath, profilerArguments, options...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid phpspy arguments: %w", err)
+		}
+		return phpspyProfiler, nil
 	default:
 		return nil, fmt.Errorf("unsupported profiler: %s", profilerPath)
 	}
-
-	return profiler, nil
 }
@@ -0,0 +1,210 @@
+package pyroscope
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/stack"
+)
+
+// BatchPayload combines several tag groups of one app and profile type into a single pprof request,
+// dynamic tags of each group become labels of its samples.
+type BatchPayload struct {
+	metadata    *AppMetadata
+	app         string
+	profile     collector.ProfileType
+	profileData []TagData
+}
+
+// NewBatchPayload creates a BatchPayload, all the profile data must have the same app and profile type.
+func (app *AppMetadata) NewBatchPayload(data []TagData) BatchPayload {
+	batch := BatchPayload{
+		metadata:    app,
+		profileData: data,
+	}
+	if len(data) > 0 {
+		batch.app = data[0].App()
+		batch.profile = data[0].Profile()
+	}
+	return batch
+}
+
+// batchKey groups profile data that can be sent in one BatchPayload.
+type batchKey struct {
+	app     string
+	profile collector.ProfileType
+}
+
+// GroupBatches splits profile data into batches of the same app and profile type, keeping their order.
+func GroupBatches(data []TagData) [][]TagData {
+	var batches [][]TagData
+	indexes := make(map[batchKey]int)
+	for _, tagData := range data {
+		key := batchKey{app: tagData.App(), profile: tagData.Profile()}
+		index, ok := indexes[key]
+		if !ok {
+			index = len(batches)
+			indexes[key] = index
+			batches = append(batches, nil)
+		}
+		batches[index] = append(batches[index], tagData)
+	}
+	return batches
+}
+
+// ContentType returns the content type of the pprof format.
+func (batch BatchPayload) ContentType() string {
+	return "application/octet-stream"
+}
+
+// Body returns the uncompressed pprof profile, samples of each tag group are labeled with its dynamic tags.
+func (batch BatchPayload) Body() []byte {
+	sampleType := &profile.ValueType{Type: "samples", Unit: "count"}
+	if batch.profile == collector.ProfileMemory {
+		sampleType = &profile.ValueType{Type: memorySampleType, Unit: "bytes"}
+	}
+
+	from, until := batch.timeRange()
+	prof := &profile.Profile{
+		SampleType:    []*profile.ValueType{sampleType},
+		PeriodType:    sampleType,
+		Period:        1,
+		TimeNanos:     from.UnixNano(),
+		DurationNanos: until.Sub(from).Nanoseconds(),
+	}
+
+	builder := newPprofBuilder(prof)
+	for _, tagData := range batch.profileData {
+		labels := tagLabels(tagData.Tags())
+
+		// interned stacks are converted without folding them
+		if interned, ok := tagData.(stackData); ok && interned.Stacks() != nil {
+			table := interned.StackTable()
+			for id, value := range interned.Stacks() {
+				frameIDs := table.FrameIDs(id)
+				sample := builder.sample(len(frameIDs), value, labels)
+				for _, frameID := range frameIDs {
+					sample.Location = append(sample.Location, builder.frameLocation(table, frameID))
+				}
+			}
+			continue
+		}
+
+		for folded, value := range tagData.Data() {
+			frames := strings.Split(folded, ";")
+			sample := builder.sample(len(frames), value, labels)
+			// folded stacks are root first, pprof locations are leaf first
+			for i := len(frames) - 1; i >= 0; i-- {
+				sample.Location = append(sample.Location, builder.location(frames[i]))
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	// writing to a bytes.Buffer doesn't fail
+	_ = prof.WriteUncompressed(&buffer)
+	return buffer.Bytes()
+}
+
+// stackData is implemented by profile data with interned stacks, such as collector.TagCollection.
+type stackData interface {
+	Stacks() map[stack.ID]int
+	StackTable() *stack.Table
+}
+
+// pprofBuilder adds samples to a profile, each frame has one function and location.
+type pprofBuilder struct {
+	prof      *profile.Profile
+	locations map[string]*profile.Location
+	frames    map[tableFrame]*profile.Location
+}
+
+// tableFrame is an interned frame, frame IDs of different tables are different frames.
+type tableFrame struct {
+	table *stack.Table
+	frame stack.FrameID
+}
+
+func newPprofBuilder(prof *profile.Profile) *pprofBuilder {
+	return &pprofBuilder{
+		prof:      prof,
+		locations: make(map[string]*profile.Location),
+		frames:    make(map[tableFrame]*profile.Location),
+	}
+}
+
+// sample adds a sample without locations.
+func (builder *pprofBuilder) sample(depth int, value int, labels map[string][]string) *profile.Sample {
+	sample := &profile.Sample{
+		Location: make([]*profile.Location, 0, depth),
+		Value:    []int64{int64(value)},
+		Label:    labels,
+	}
+	builder.prof.Sample = append(builder.prof.Sample, sample)
+	return sample
+}
+
+// location returns the location of the frame, adding it if it's new.
+func (builder *pprofBuilder) location(frame string) *profile.Location {
+	if location, ok := builder.locations[frame]; ok {
+		return location
+	}
+	prof := builder.prof
+	function := &profile.Function{ID: uint64(len(prof.Function) + 1), Name: frame}
+	location := &profile.Location{ID: uint64(len(prof.Location) + 1), Line: []profile.Line{{Function: function}}}
+	prof.Function = append(prof.Function, function)
+	prof.Location = append(prof.Location, location)
+	builder.locations[frame] = location
+	return location
+}
+
+// frameLocation returns the location of the interned frame.
+func (builder *pprofBuilder) frameLocation(table *stack.Table, frameID stack.FrameID) *profile.Location {
+	key := tableFrame{table: table, frame: frameID}
+	if location, ok := builder.frames[key]; ok {
+		return location
+	}
+	location := builder.location(table.Frame(frameID))
+	builder.frames[key] = location
+	return location
+}
+
+// QueryString generates the URL query string, the name holds only static tags as dynamic ones are sample labels.
+// The name and parameters are the ones of folded requests of the same profile data, so batched data lands
+// in the same series as unbatched one.
+func (batch BatchPayload) QueryString() string {
+	from, until := batch.timeRange()
+	return batch.metadata.queryString(batch.app, batch.profile, "", from, until, "pprof")
+}
+
+// timeRange returns the earliest start and the latest end of the batched profile data.
+func (batch BatchPayload) timeRange() (time.Time, time.Time) {
+	var from, until time.Time
+	for i, tagData := range batch.profileData {
+		if i == 0 || tagData.From().Before(from) {
+			from = tagData.From()
+		}
+		if i == 0 || tagData.Until().After(until) {
+			until = tagData.Until()
+		}
+	}
+	return from, until
+}
+
+// tagLabels converts tags in `key=value,key=value` format to pprof labels.
+func tagLabels(tags string) map[string][]string {
+	if tags == "" {
+		return nil
+	}
+	labels := make(map[string][]string)
+	for _, pair := range strings.Split(tags, ",") {
+		if key, value, found := strings.Cut(pair, "="); found {
+			labels[key] = append(labels[key], value)
+		}
+	}
+	return labels
+}
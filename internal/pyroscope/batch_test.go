@@ -0,0 +1,273 @@
+package pyroscope_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/hakastein/gospy/internal/collector"
+	"github.com/hakastein/gospy/internal/pyroscope"
+	"github.com/hakastein/gospy/internal/stack"
+)
+
+// consumeAll consumes all tag groups of the collector.
+func consumeAll(c *collector.TraceCollector) []pyroscope.TagData {
+	var data []pyroscope.TagData
+	for {
+		tagData, ok := c.ConsumeTag()
+		if !ok {
+			return data
+		}
+		data = append(data, tagData)
+	}
+}
+
+func TestGroupBatches(t *testing.T) {
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := collector.NewTraceCollector()
+	for _, sample := range []collector.Sample{
+		{Time: baseTime, Trace: "main", Tags: "uri=/a"},
+		{Time: baseTime, Trace: "main", Tags: "uri=/a", Profile: collector.ProfileMemory, Value: 10},
+		{Time: baseTime, Trace: "main", Tags: "uri=/b"},
+		{Time: baseTime, Trace: "main", Tags: "uri=/b", App: "tenant1"},
+	} {
+		c.AddSample(&sample)
+	}
+
+	batches := pyroscope.GroupBatches(consumeAll(c))
+
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Equal(t, collector.ProfileMemory, batches[1][0].Profile())
+	assert.Equal(t, "tenant1", batches[2][0].App())
+}
+
+func TestBatchPayload(t *testing.T) {
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := collector.NewTraceCollector()
+	for _, sample := range []collector.Sample{
+		{Time: baseTime.Add(time.Second), Trace: "main;foo", Tags: "uri=/a"},
+		{Time: baseTime.Add(time.Second), Trace: "main;foo", Tags: "uri=/a"},
+		{Time: baseTime.Add(10 * time.Second), Trace: "main;bar", Tags: "uri=/b,method=GET"},
+		{Time: baseTime, Trace: "main", Tags: ""},
+	} {
+		c.AddSample(&sample)
+	}
+
+	batch := pyroscope.NewAppMetadata("myapp", "env=prod", 100).NewBatchPayload(consumeAll(c))
+
+	assert.Equal(t, "name="+url.QueryEscape("myapp{env=prod}")+
+		"&from=1672531200&until=1672531210&sampleRate=100&format=pprof", batch.QueryString())
+
+	prof, err := profile.Parse(bytes.NewReader(batch.Body()))
+	require.NoError(t, err)
+	require.NoError(t, prof.CheckValid())
+	assert.Equal(t, "samples", prof.SampleType[0].Type)
+	assert.Equal(t, baseTime.UnixNano(), prof.TimeNanos)
+	assert.Equal(t, (10 * time.Second).Nanoseconds(), prof.DurationNanos)
+	assert.Len(t, prof.Location, 3, "frames must be shared between samples")
+
+	samples := make(map[string]*profile.Sample)
+	for _, sample := range prof.Sample {
+		var stack string
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			if stack != "" {
+				stack += ";"
+			}
+			stack += sample.Location[i].Line[0].Function.Name
+		}
+		samples[stack] = sample
+	}
+	require.Len(t, samples, 3)
+	assert.Equal(t, []int64{2}, samples["main;foo"].Value)
+	assert.Equal(t, map[string][]string{"uri": {"/a"}}, samples["main;foo"].Label)
+	assert.Equal(t, map[string][]string{"uri": {"/b"}, "method": {"GET"}}, samples["main;bar"].Label)
+	assert.Empty(t, samples["main"].Label)
+}
+
+// TestBatchPayload_QueryStringSeries tests that batched data is sent with the name and parameters of unbatched data,
+// so enabling batching doesn't move profiles to other series.
+func TestBatchPayload_QueryStringSeries(t *testing.T) {
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta := pyroscope.NewAppMetadata("myapp", "env=prod", 100)
+
+	for _, profileType := range []collector.ProfileType{collector.ProfileCPU, collector.ProfileMemory} {
+		c := collector.NewTraceCollector()
+		c.AddSample(&collector.Sample{Time: baseTime, Trace: "main;foo", Profile: profileType, Value: 10})
+		data := consumeAll(c)
+		require.Len(t, data, 1)
+
+		unbatched := meta.NewPayload(data[0]).QueryString()
+		batched := meta.NewBatchPayload(data).QueryString()
+
+		assert.Equal(t, strings.Replace(unbatched, "&format=folded", "&format=pprof", 1), batched,
+			"profile type %d", profileType)
+		if profileType == collector.ProfileMemory {
+			assert.Contains(t, batched, "name="+url.QueryEscape("myapp.inuse_space{env=prod}")+"&")
+			assert.Contains(t, batched, "&units=bytes&aggregationType=average")
+		}
+	}
+}
+
+func TestBatchPayload_FoldedData(t *testing.T) {
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := collector.NewTraceCollector()
+	c.AddSample(&collector.Sample{Time: baseTime, Trace: "main;foo", Tags: "uri=/a"})
+	interned, ok := c.ConsumeTag()
+	require.True(t, ok)
+	folded := collector.NewTagCollection(baseTime, baseTime, "uri=/b", map[string]int{"main;bar": 3})
+
+	batch := pyroscope.NewAppMetadata("myapp", "", 100).NewBatchPayload([]pyroscope.TagData{interned, folded})
+
+	prof, err := profile.Parse(bytes.NewReader(batch.Body()))
+	require.NoError(t, err)
+	require.NoError(t, prof.CheckValid())
+	require.Len(t, prof.Sample, 2)
+	assert.Len(t, prof.Location, 3, "frames of interned and folded stacks must be shared")
+	assert.Equal(t, []int64{3}, prof.Sample[1].Value)
+	assert.Equal(t, "bar", prof.Sample[1].Location[0].Line[0].Function.Name)
+}
+
+func TestWorker_Batching(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := collector.NewTraceCollector()
+	for _, tags := range []string{"uri=/a", "uri=/b", "uri=/c"} {
+		c.AddSample(&collector.Sample{Time: time.Now(), Trace: "main;foo", Tags: tags})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statsChannel := make(chan *pyroscope.RequestStats, 10)
+	client := pyroscope.NewClient(server.URL, "", server.Client())
+	// two tag groups fit into a batch
+	worker := pyroscope.NewWorker(client, pyroscope.NewAppMetadata("myapp", "", 100), c, rate.NewLimiter(rate.Inf, 0), 20, statsChannel)
+	worker.Start(ctx)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case stats := <-statsChannel:
+			assert.True(t, stats.Success)
+		case <-time.After(time.Second):
+			t.Fatal("batch wasn't sent")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, bodies, 2)
+	var samples []int
+	for _, body := range bodies {
+		prof, err := profile.Parse(bytes.NewReader(body))
+		require.NoError(t, err)
+		samples = append(samples, len(prof.Sample))
+	}
+	assert.Equal(t, []int{2, 1}, samples)
+}
+
+// TestWorker_BatchExceedsBurst tests that a batch whose pprof body exceeds the rate limit burst
+// is split and sent in parts instead of being dropped.
+func TestWorker_BatchExceedsBurst(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tags := []string{"uri=/a", "uri=/b", "uri=/c", "uri=/d"}
+	addSamples := func(c *collector.TraceCollector, tags []string) {
+		for _, tag := range tags {
+			c.AddSample(&collector.Sample{Time: baseTime, Trace: "main;foo", Tags: tag})
+		}
+	}
+	meta := pyroscope.NewAppMetadata("myapp", "", 100)
+
+	// the burst fits half of the tag groups, but not all of them
+	halves := collector.NewTraceCollector()
+	addSamples(halves, tags)
+	burst := len(meta.NewBatchPayload(consumeAll(halves)[:2]).Body())
+
+	c := collector.NewTraceCollector()
+	addSamples(c, tags)
+	require.Greater(t, len(meta.NewBatchPayload(consumeAll(c)).Body()), burst)
+	addSamples(c, tags)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statsChannel := make(chan *pyroscope.RequestStats, 10)
+	client := pyroscope.NewClient(server.URL, "", server.Client())
+	// the batch size measured in the folded format lets all tag groups into one batch
+	worker := pyroscope.NewWorker(client, meta, c, rate.NewLimiter(rate.Limit(burst*10), burst), 1024, statsChannel)
+	worker.Start(ctx)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case stats := <-statsChannel:
+			assert.True(t, stats.Success, "the split batches must be sent")
+		case <-time.After(time.Second):
+			t.Fatal("batch wasn't sent")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, bodies, 2)
+	var samples []int
+	for _, body := range bodies {
+		prof, err := profile.Parse(bytes.NewReader(body))
+		require.NoError(t, err)
+		samples = append(samples, len(prof.Sample))
+	}
+	assert.Equal(t, []int{2, 2}, samples)
+}
+
+func TestBatchPayload_StackTables(t *testing.T) {
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	// every stack fills the current table, so the collections are from different tables with the same frame IDs
+	c := collector.NewTraceCollector(collector.WithStackTables(stack.NewTables(1)))
+	c.AddSample(&collector.Sample{Time: baseTime, Trace: "main;foo", Tags: "uri=/a"})
+	c.AddSample(&collector.Sample{Time: baseTime, Trace: "cli;bar", Tags: "uri=/b"})
+	data := consumeAll(c)
+	require.Len(t, data, 2)
+	require.NotSame(t, data[0].(*collector.TagCollection).StackTable(), data[1].(*collector.TagCollection).StackTable())
+
+	batch := pyroscope.NewAppMetadata("myapp", "", 100).NewBatchPayload(data)
+
+	prof, err := profile.Parse(bytes.NewReader(batch.Body()))
+	require.NoError(t, err)
+	require.NoError(t, prof.CheckValid())
+	require.Len(t, prof.Sample, 2)
+	assert.Len(t, prof.Location, 4, "frames of different tables must not be shared")
+	stacks := make(map[string]bool)
+	for _, sample := range prof.Sample {
+		stacks[sample.Location[1].Line[0].Function.Name+";"+sample.Location[0].Line[0].Function.Name] = true
+	}
+	assert.Equal(t, map[string]bool{"main;foo": true, "cli;bar": true}, stacks)
+}
@@ -1,6 +1,7 @@
 package pyroscope
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -14,11 +15,51 @@ import (
 	"github.com/hakastein/gospy/internal/version"
 )
 
+// tenantHeader is the header multi-tenant Pyroscope and Grafana Cloud use to select the tenant.
+const tenantHeader = "X-Scope-OrgID"
+
 // Client handles sending data to Pyroscope server.
 type Client struct {
-	httpClient *http.Client
-	url        string
-	authToken  string
+	httpClient    *http.Client
+	url           string
+	authToken     string
+	basicUser     string
+	basicPassword string
+	tenantID      string
+	headers       http.Header
+	compression   Compression
+}
+
+// ClientOption configures optional Client features.
+type ClientOption func(client *Client)
+
+// WithBasicAuth authenticates requests with basic auth instead of the bearer token.
+func WithBasicAuth(user, password string) ClientOption {
+	return func(client *Client) {
+		client.basicUser = user
+		client.basicPassword = password
+	}
+}
+
+// WithTenantID sets the X-Scope-OrgID header of multi-tenant setups.
+func WithTenantID(tenantID string) ClientOption {
+	return func(client *Client) {
+		client.tenantID = tenantID
+	}
+}
+
+// WithHeaders adds extra headers to requests, they override headers set by the client.
+func WithHeaders(headers http.Header) ClientOption {
+	return func(client *Client) {
+		client.headers = headers
+	}
+}
+
+// WithCompression compresses request bodies and sets their Content-Encoding.
+func WithCompression(compression Compression) ClientOption {
+	return func(client *Client) {
+		client.compression = compression
+	}
 }
 
 type ErrorResponse struct {
@@ -31,31 +72,68 @@ func NewClient(
 	url string,
 	authToken string,
 	httpClient *http.Client,
+	options ...ClientOption,
 ) *Client {
-	return &Client{
+	client := &Client{
 		httpClient: httpClient,
 		url:        strings.TrimSuffix(url, "/") + "/ingest",
 		authToken:  authToken,
 	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
 }
 
-// Send sends the profile data to Pyroscope and returns the HTTP status code and any error encountered.
+// Encode returns the request body as it's sent, compressed with the client compression.
+func (client *Client) Encode(request Request) ([]byte, error) {
+	return client.compression.Compress(request.Body())
+}
+
+// Send encodes the request body and sends the profile data to Pyroscope.
+// It returns an error if the request fails or the server doesn't respond with an empty 200 OK.
 func (client *Client) Send(
 	ctx context.Context,
-	payload Payload,
+	request Request,
+) error {
+	body, err := client.Encode(request)
+	if err != nil {
+		return err
+	}
+	return client.SendEncoded(ctx, request, body)
+}
+
+// SendEncoded sends the profile data with the body returned by Encode, so it isn't encoded twice.
+func (client *Client) SendEncoded(
+	ctx context.Context,
+	request Request,
+	body []byte,
 ) error {
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", client.url, payload.BodyReader())
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", client.url, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("error creating request: %w", err)
 	}
 
-	httpReq.Header.Set("Content-Type", "text/plain")
+	httpReq.Header.Set("Content-Type", request.ContentType())
+	if client.compression != CompressionNone {
+		httpReq.Header.Set("Content-Encoding", client.compression.String())
+	}
 	httpReq.Header.Set("User-Agent", fmt.Sprintf("gospy/%s/%s", version.Get(), runtime.Version()))
-	if client.authToken != "" {
+	if client.basicUser != "" {
+		httpReq.SetBasicAuth(client.basicUser, client.basicPassword)
+	} else if client.authToken != "" {
 		httpReq.Header.Set("Authorization", "Bearer "+client.authToken)
 	}
+	if client.tenantID != "" {
+		httpReq.Header.Set(tenantHeader, client.tenantID)
+	}
+	for name, values := range client.headers {
+		httpReq.Header[name] = values
+	}
 
-	httpReq.URL.RawQuery = payload.QueryString()
+	httpReq.URL.RawQuery = request.QueryString()
 
 	log.Debug().Str("query", httpReq.URL.RawQuery).Msg("requesting pyroscope")
 
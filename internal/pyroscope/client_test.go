@@ -132,6 +132,32 @@ func TestClient_Send(t *testing.T) {
 		}
 	})
 
+	t.Run("client options", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			assert.True(t, ok, "basic auth should be used instead of the bearer token")
+			assert.Equal(t, "123456", user)
+			assert.Equal(t, "glc_token", password)
+			assert.Equal(t, "tenant-a", r.Header.Get("X-Scope-OrgID"))
+			assert.Equal(t, []string{"profiling", "php"}, r.Header.Values("X-Team"))
+			assert.Equal(t, "custom-agent", r.Header.Get("User-Agent"))
+			w.WriteHeader(http.StatusOK)
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := pyroscope.NewClient(server.URL, "secret-token", server.Client(),
+			pyroscope.WithBasicAuth("123456", "glc_token"),
+			pyroscope.WithTenantID("tenant-a"),
+			pyroscope.WithHeaders(http.Header{
+				"X-Team":     {"profiling", "php"},
+				"User-Agent": {"custom-agent"},
+			}),
+		)
+		err := client.Send(context.Background(), testPayload)
+		require.NoError(t, err)
+	})
+
 	t.Run("server errors", func(t *testing.T) {
 		tests := []struct {
 			name        string
@@ -0,0 +1,87 @@
+package pyroscope
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the Content-Encoding of request bodies sent to Pyroscope.
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+var compressionNames = map[string]Compression{
+	"none": CompressionNone,
+	"gzip": CompressionGzip,
+	"zstd": CompressionZstd,
+}
+
+var (
+	gzipWriters = sync.Pool{
+		New: func() any {
+			return gzip.NewWriter(nil)
+		},
+	}
+	// zstdEncoder is created on first use, EncodeAll is safe for concurrent use.
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+)
+
+// ParseCompression parses a compression name: none, gzip or zstd. An empty name means none.
+func ParseCompression(name string) (Compression, error) {
+	if name == "" {
+		return CompressionNone, nil
+	}
+	compression, ok := compressionNames[name]
+	if !ok {
+		return CompressionNone, fmt.Errorf("unknown compression `%s`, expected none, gzip or zstd", name)
+	}
+	return compression, nil
+}
+
+// String returns the compression name, which is also its Content-Encoding.
+func (compression Compression) String() string {
+	switch compression {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// Compress returns the compressed body, the body itself is returned without compression.
+func (compression Compression) Compress(body []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buffer bytes.Buffer
+		writer := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(writer)
+		writer.Reset(&buffer)
+		if _, err := writer.Write(body); err != nil {
+			return nil, fmt.Errorf("gzip compression failed: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("gzip compression failed: %w", err)
+		}
+		return buffer.Bytes(), nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("zstd compression failed: %w", err)
+		}
+		return encoder.EncodeAll(body, make([]byte, 0, len(body)/4)), nil
+	default:
+		return body, nil
+	}
+}
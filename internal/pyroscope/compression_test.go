@@ -0,0 +1,69 @@
+package pyroscope_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/pyroscope"
+)
+
+func TestParseCompression(t *testing.T) {
+	for name, want := range map[string]pyroscope.Compression{
+		"":     pyroscope.CompressionNone,
+		"none": pyroscope.CompressionNone,
+		"gzip": pyroscope.CompressionGzip,
+		"zstd": pyroscope.CompressionZstd,
+	} {
+		got, err := pyroscope.ParseCompression(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := pyroscope.ParseCompression("brotli")
+	assert.EqualError(t, err, "unknown compression `brotli`, expected none, gzip or zstd")
+}
+
+func TestCompression_Compress(t *testing.T) {
+	body := []byte(strings.Repeat("main;controller;action 5\nmain;service;process 3\n", 100))
+
+	t.Run("none", func(t *testing.T) {
+		compressed, err := pyroscope.CompressionNone.Compress(body)
+		require.NoError(t, err)
+		assert.Equal(t, body, compressed)
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		// twice to reuse a pooled writer
+		for i := 0; i < 2; i++ {
+			compressed, err := pyroscope.CompressionGzip.Compress(body)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(body))
+
+			reader, err := gzip.NewReader(bytes.NewReader(compressed))
+			require.NoError(t, err)
+			decompressed, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, body, decompressed)
+		}
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		compressed, err := pyroscope.CompressionZstd.Compress(body)
+		require.NoError(t, err)
+		assert.Less(t, len(compressed), len(body))
+
+		decoder, err := zstd.NewReader(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+		decompressed, err := decoder.DecodeAll(compressed, nil)
+		require.NoError(t, err)
+		assert.Equal(t, body, decompressed)
+	})
+}
@@ -0,0 +1,120 @@
+package pyroscope
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Megabyte is the number of bytes in a megabyte, sizes are set in megabytes by flags.
+const Megabyte = 1024 * 1024
+
+// Destination is a Pyroscope server profiles are sent to. Each destination has its own client,
+// rate limiter, workers and statistics, so a failing destination doesn't block the others.
+type Destination struct {
+	Name      string
+	URL       string
+	AuthToken string
+	BasicAuth string
+	TenantID  string
+	Workers   int
+	// RateLimit and RateBurst are ingestion limits in bytes per second.
+	RateLimit   int
+	RateBurst   int
+	Compression Compression
+	// BatchSize is the maximum size in bytes of profile data combined into one request, zero disables batching.
+	BatchSize int
+}
+
+// ParseDestination parses a destination in `key=value,key=value` format. Supported keys are url (required),
+// name, auth, basic-auth, tenant-id, workers, rate-mb, rate-burst-mb, compression and batch-mb.
+// Omitted settings are taken from defaults, the name defaults to the URL host.
+func ParseDestination(input string, defaults Destination) (Destination, error) {
+	destination := defaults
+	destination.Name, destination.URL = "", ""
+
+	for _, field := range strings.Split(input, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return Destination{}, fmt.Errorf("invalid destination field `%s`, expected format is key=value", field)
+		}
+
+		var err error
+		switch key {
+		case "name":
+			destination.Name = value
+		case "url":
+			destination.URL = value
+		case "auth":
+			destination.AuthToken = value
+		case "basic-auth":
+			destination.BasicAuth = value
+		case "tenant-id":
+			destination.TenantID = value
+		case "workers":
+			destination.Workers, err = strconv.Atoi(value)
+			if err == nil && destination.Workers < 1 {
+				err = errors.New("must be positive")
+			}
+		case "rate-mb":
+			destination.RateLimit, err = parseMegabytes(value)
+		case "rate-burst-mb":
+			destination.RateBurst, err = parseMegabytes(value)
+		case "compression":
+			destination.Compression, err = ParseCompression(value)
+		case "batch-mb":
+			var megabytes float64
+			// zero or less disables batching like --pyroscope-batch-mb=0
+			if megabytes, err = strconv.ParseFloat(value, 64); err == nil {
+				destination.BatchSize = int(max(megabytes, 0) * Megabyte)
+			}
+		default:
+			return Destination{}, fmt.Errorf("unknown destination field `%s`", key)
+		}
+		if err != nil {
+			return Destination{}, fmt.Errorf("invalid destination %s `%s`: %v", key, value, err)
+		}
+	}
+
+	if destination.URL == "" {
+		return Destination{}, fmt.Errorf("destination `%s` has no url", input)
+	}
+	if destination.Name == "" {
+		parsed, err := url.Parse(destination.URL)
+		if err != nil || parsed.Host == "" {
+			return Destination{}, fmt.Errorf("invalid destination url `%s`", destination.URL)
+		}
+		destination.Name = parsed.Host
+	}
+
+	if err := destination.Validate(); err != nil {
+		return Destination{}, err
+	}
+
+	return destination, nil
+}
+
+// Validate checks that settings of the destination fit together.
+// Batches bigger than the rate limit burst would never pass the rate limiter.
+func (destination Destination) Validate() error {
+	if destination.BatchSize > destination.RateBurst {
+		return fmt.Errorf(
+			"batch size of destination %s exceeds its rate limit burst, batch-mb must not be greater than rate-burst-mb",
+			destination.Name,
+		)
+	}
+	return nil
+}
+
+func parseMegabytes(value string) (int, error) {
+	megabytes, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if megabytes <= 0 {
+		return 0, errors.New("must be positive")
+	}
+	return int(megabytes * Megabyte), nil
+}
@@ -0,0 +1,106 @@
+package pyroscope_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/pyroscope"
+)
+
+func TestParseDestination(t *testing.T) {
+	defaults := pyroscope.Destination{
+		Name:      "default",
+		URL:       "http://default:4040",
+		AuthToken: "token",
+		Workers:   5,
+		RateLimit: 4 * 1024 * 1024,
+		RateBurst: 8 * 1024 * 1024,
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    pyroscope.Destination
+		wantErr string
+	}{
+		{
+			name:  "url only",
+			input: "url=http://pyroscope:4040",
+			want: pyroscope.Destination{
+				Name:      "pyroscope:4040",
+				URL:       "http://pyroscope:4040",
+				AuthToken: "token",
+				Workers:   5,
+				RateLimit: 4 * 1024 * 1024,
+				RateBurst: 8 * 1024 * 1024,
+			},
+		},
+		{
+			name:  "all fields",
+			input: "name=backup, url=https://backup:4040, auth=secret, basic-auth=user:pass, tenant-id=team, workers=2, rate-mb=0.5, rate-burst-mb=1, compression=zstd",
+			want: pyroscope.Destination{
+				Name:        "backup",
+				URL:         "https://backup:4040",
+				AuthToken:   "secret",
+				BasicAuth:   "user:pass",
+				TenantID:    "team",
+				Workers:     2,
+				RateLimit:   512 * 1024,
+				RateBurst:   1024 * 1024,
+				Compression: pyroscope.CompressionZstd,
+			},
+		},
+		{
+			name:  "batch size",
+			input: "url=http://pyroscope:4040,batch-mb=1.5",
+			want: pyroscope.Destination{
+				Name:      "pyroscope:4040",
+				URL:       "http://pyroscope:4040",
+				AuthToken: "token",
+				Workers:   5,
+				RateLimit: 4 * 1024 * 1024,
+				RateBurst: 8 * 1024 * 1024,
+				BatchSize: 1536 * 1024,
+			},
+		},
+		{
+			name:  "batching disabled",
+			input: "url=http://pyroscope:4040,batch-mb=0.0",
+			want: pyroscope.Destination{
+				Name:      "pyroscope:4040",
+				URL:       "http://pyroscope:4040",
+				AuthToken: "token",
+				Workers:   5,
+				RateLimit: 4 * 1024 * 1024,
+				RateBurst: 8 * 1024 * 1024,
+			},
+		},
+		{name: "missing url", input: "name=backup", wantErr: "destination `name=backup` has no url"},
+		{
+			name:    "batch size exceeds burst",
+			input:   "name=backup,url=http://backup,batch-mb=16",
+			wantErr: "batch size of destination backup exceeds its rate limit burst, batch-mb must not be greater than rate-burst-mb",
+		},
+		{name: "invalid batch size", input: "url=http://backup,batch-mb=big", wantErr: "invalid destination batch-mb `big`: strconv.ParseFloat: parsing \"big\": invalid syntax"},
+		{name: "invalid field", input: "url", wantErr: "invalid destination field `url`, expected format is key=value"},
+		{name: "unknown field", input: "url=http://backup,zone=eu", wantErr: "unknown destination field `zone`"},
+		{name: "invalid workers", input: "url=http://backup,workers=0", wantErr: "invalid destination workers `0`: must be positive"},
+		{name: "invalid rate", input: "url=http://backup,rate-mb=fast", wantErr: "invalid destination rate-mb `fast`: strconv.ParseFloat: parsing \"fast\": invalid syntax"},
+		{name: "invalid compression", input: "url=http://backup,compression=lz4", wantErr: "invalid destination compression `lz4`: unknown compression `lz4`, expected none, gzip or zstd"},
+		{name: "url without host", input: "url=backup", wantErr: "invalid destination url `backup`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pyroscope.ParseDestination(tt.input, defaults)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/hakastein/gospy/internal/collector"
 )
 
 type TagData interface {
 	Tags() string
+	App() string
+	Profile() collector.ProfileType
 	From() time.Time
 	Until() time.Time
 	Data() map[string]int
@@ -20,6 +24,11 @@ type TagData interface {
 const (
 	AppNameStringEstimatedLength  = 50
 	AppQueryStringEstimatedLength = 150
+
+	// memorySampleType is the sample type of memory profiles, Pyroscope ingests them as memory:inuse_space:bytes.
+	memorySampleType = "inuse_space"
+	// memoryProfileSuffix makes Pyroscope ingest folded memory samples as the memory:inuse_space:bytes profile type.
+	memoryProfileSuffix = "." + memorySampleType
 )
 
 // AppMetadata represents pyroscope application's static information.
@@ -38,6 +47,13 @@ func NewAppMetadata(appName, staticTags string, sampleRate int) *AppMetadata {
 	}
 }
 
+// Request is profile data sent to Pyroscope in a single ingest request.
+type Request interface {
+	QueryString() string
+	ContentType() string
+	Body() []byte
+}
+
 // Payload represents data to be sent to Pyroscope, including app metadata and profile information.
 type Payload struct {
 	metadata    *AppMetadata
@@ -53,11 +69,19 @@ func (app *AppMetadata) NewPayload(data TagData) Payload {
 }
 
 // fullAppName combines the app name with static and dynamic tags in Pyroscope format.
-func (app *AppMetadata) fullAppName(dynamicTags string) string {
+// A routed app name replaces the default one.
+func (app *AppMetadata) fullAppName(routedApp string, profile collector.ProfileType, dynamicTags string) string {
 	var builder strings.Builder
 	builder.Grow(AppNameStringEstimatedLength)
 
-	builder.WriteString(app.appName)
+	if routedApp != "" {
+		builder.WriteString(routedApp)
+	} else {
+		builder.WriteString(app.appName)
+	}
+	if profile == collector.ProfileMemory {
+		builder.WriteString(memoryProfileSuffix)
+	}
 	builder.WriteRune('{')
 	if app.staticTags != "" {
 		builder.WriteString(app.staticTags)
@@ -73,8 +97,8 @@ func (app *AppMetadata) fullAppName(dynamicTags string) string {
 	return builder.String()
 }
 
-// BodyReader returns an io.Reader that produces the profile data in Pyroscope's folded format.
-func (payload *Payload) BodyReader() io.Reader {
+// Body renders the profile data in Pyroscope's folded format.
+func (payload Payload) Body() []byte {
 	b := make([]byte, 0, payload.profileData.Len())
 	first := true
 	for sample, count := range payload.profileData.Data() {
@@ -87,28 +111,57 @@ func (payload *Payload) BodyReader() io.Reader {
 		b = append(b, ' ')
 		b = strconv.AppendInt(b, int64(count), 10)
 	}
-	return bytes.NewReader(b)
+	return b
+}
 
+// ContentType returns the content type of the folded format.
+func (payload Payload) ContentType() string {
+	return "text/plain"
+}
+
+// BodyReader returns an io.Reader that produces the profile data in Pyroscope's folded format.
+func (payload Payload) BodyReader() io.Reader {
+	return bytes.NewReader(payload.Body())
 }
 
 // QueryString generates the URL query string with all parameters for the Pyroscope API.
-func (payload *Payload) QueryString() string {
+func (payload Payload) QueryString() string {
+	return payload.metadata.queryString(
+		payload.profileData.App(),
+		payload.profileData.Profile(),
+		payload.profileData.Tags(),
+		payload.profileData.From(),
+		payload.profileData.Until(),
+		"folded",
+	)
+}
+
+// queryString generates the URL query string of a request in the format, folded and batched pprof requests
+// of the same profile data have the same parameters, so they're ingested into the same series.
+func (app *AppMetadata) queryString(
+	routedApp string,
+	profile collector.ProfileType,
+	dynamicTags string,
+	from time.Time,
+	until time.Time,
+	format string,
+) string {
 	var builder strings.Builder
 	builder.Grow(AppQueryStringEstimatedLength)
 
-	name := url.QueryEscape(payload.metadata.fullAppName(payload.profileData.Tags()))
-	from := payload.profileData.From().Unix()
-	to := payload.profileData.Until().Unix()
-
 	builder.WriteString("name=")
-	builder.WriteString(name)
+	builder.WriteString(url.QueryEscape(app.fullAppName(routedApp, profile, dynamicTags)))
 	builder.WriteString("&from=")
-	builder.WriteString(strconv.FormatInt(from, 10))
+	builder.WriteString(strconv.FormatInt(from.Unix(), 10))
 	builder.WriteString("&until=")
-	builder.WriteString(strconv.FormatInt(to, 10))
+	builder.WriteString(strconv.FormatInt(until.Unix(), 10))
 	builder.WriteString("&sampleRate=")
-	builder.WriteString(strconv.Itoa(payload.metadata.sampleRate))
-	builder.WriteString("&format=folded")
+	builder.WriteString(strconv.Itoa(app.sampleRate))
+	builder.WriteString("&format=")
+	builder.WriteString(format)
+	if profile == collector.ProfileMemory {
+		builder.WriteString("&spyName=phpspy&units=bytes&aggregationType=average")
+	}
 
 	return builder.String()
 }
@@ -1,6 +1,7 @@
 package pyroscope
 
 import (
+	"bytes"
 	"io"
 	"net/url"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/pprof/profile"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -18,6 +20,7 @@ func TestAppMetadata_fullAppName(t *testing.T) {
 	tests := []struct {
 		name        string
 		appName     string
+		routedApp   string
 		staticTags  string
 		dynamicTags string
 		expected    string
@@ -46,12 +49,20 @@ func TestAppMetadata_fullAppName(t *testing.T) {
 			dynamicTags: "user=admin",
 			expected:    "myapp{env=prod,user=admin}",
 		},
+		{
+			name:        "routed app",
+			appName:     "myapp",
+			routedApp:   "tenant1",
+			staticTags:  "env=prod",
+			dynamicTags: "user=admin",
+			expected:    "tenant1{env=prod,user=admin}",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			meta := NewAppMetadata(tt.appName, tt.staticTags, 100)
-			result := meta.fullAppName(tt.dynamicTags)
+			result := meta.fullAppName(tt.routedApp, collector.ProfileCPU, tt.dynamicTags)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
@@ -80,6 +91,58 @@ func TestPayload_QueryString(t *testing.T) {
 	assert.Equal(t, expectedQuery, payload.QueryString())
 }
 
+func TestPayload_QueryStringMemoryProfile(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	traces := collector.NewTraceCollector()
+	traces.AddSample(&collector.Sample{
+		Time:    now,
+		Trace:   "main;foo",
+		Tags:    "region=us-west",
+		Profile: collector.ProfileMemory,
+		Value:   2048,
+	})
+	tagData, ok := traces.ConsumeTag()
+	require.True(t, ok)
+
+	meta := NewAppMetadata("myapp", "env=prod", 100)
+	payload := meta.NewPayload(tagData)
+
+	expectedName := url.QueryEscape("myapp.inuse_space{env=prod,region=us-west}")
+	expectedQuery := "name=" + expectedName +
+		"&from=" + strconv.FormatInt(now.Unix(), 10) +
+		"&until=" + strconv.FormatInt(now.Unix(), 10) +
+		"&sampleRate=100&format=folded&spyName=phpspy&units=bytes&aggregationType=average"
+
+	assert.Equal(t, expectedQuery, payload.QueryString())
+
+	// batched memory profiles have the same profile type as folded ones
+	prof, err := profile.Parse(bytes.NewReader(meta.NewBatchPayload([]TagData{tagData}).Body()))
+	require.NoError(t, err)
+	assert.Equal(t, []*profile.ValueType{{Type: "inuse_space", Unit: "bytes"}}, prof.SampleType)
+}
+
+func TestPayload_QueryStringRoutedApp(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	traces := collector.NewTraceCollector()
+	traces.AddSample(&collector.Sample{
+		Time:    now,
+		Trace:   "main;foo",
+		Tags:    "region=us-west",
+		App:     "tenant1",
+		Profile: collector.ProfileMemory,
+		Value:   2048,
+	})
+	tagData, ok := traces.ConsumeTag()
+	require.True(t, ok)
+
+	meta := NewAppMetadata("myapp", "env=prod", 100)
+	payload := meta.NewPayload(tagData)
+
+	assert.Contains(t, payload.QueryString(), "name="+url.QueryEscape("tenant1.inuse_space{env=prod,region=us-west}")+"&")
+}
+
 func TestPayload_BodyReader(t *testing.T) {
 	tagData := collector.NewTagCollection(
 		time.Time{},
@@ -2,12 +2,17 @@ package pyroscope
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"golang.org/x/time/rate"
 
 	"github.com/hakastein/gospy/internal/collector"
 )
 
+// ErrExceedsBurst is returned when a request body is bigger than the rate limit burst, so it can never be sent.
+var ErrExceedsBurst = errors.New("exceeds the rate limit burst")
+
 // Processor handles the business logic of processing profile data
 type Processor struct {
 	client      *Client
@@ -24,15 +29,34 @@ func NewProcessor(client *Client, appMetadata *AppMetadata, rateLimiter *rate.Li
 	}
 }
 
-// ProcessData processes a single TagCollection, respecting rate limits and sending to Pyroscope
-func (p *Processor) ProcessData(ctx context.Context, profileData *collector.TagCollection) error {
-	dataSize := profileData.Len()
+// ProcessData processes a single TagCollection, respecting rate limits and sending to Pyroscope.
+// It returns the size of the sent body, rate limits are charged with compressed bytes.
+func (p *Processor) ProcessData(ctx context.Context, profileData *collector.TagCollection) (int, error) {
+	return p.send(ctx, p.appMetadata.NewPayload(profileData))
+}
+
+// ProcessBatch sends profile data of the same app and profile type in a single pprof request,
+// see GroupBatches. It returns the size of the sent body.
+func (p *Processor) ProcessBatch(ctx context.Context, profileData []TagData) (int, error) {
+	return p.send(ctx, p.appMetadata.NewBatchPayload(profileData))
+}
+
+// send encodes the request, waits for the rate limiter and sends the request.
+func (p *Processor) send(ctx context.Context, request Request) (int, error) {
+	body, err := p.client.Encode(request)
+	if err != nil {
+		return 0, err
+	}
+
+	// the rate limiter never lets through more than its burst at once
+	if burst := p.rateLimiter.Burst(); len(body) > burst && p.rateLimiter.Limit() != rate.Inf {
+		return len(body), fmt.Errorf("request body of %d bytes %w of %d bytes", len(body), ErrExceedsBurst, burst)
+	}
 
 	// Respect rate limiting
-	if err := p.rateLimiter.WaitN(ctx, dataSize); err != nil {
-		return err
+	if err := p.rateLimiter.WaitN(ctx, len(body)); err != nil {
+		return len(body), err
 	}
 
-	payload := p.appMetadata.NewPayload(profileData)
-	return p.client.Send(ctx, payload)
+	return len(body), p.client.SendEncoded(ctx, request, body)
 }
@@ -1,10 +1,14 @@
 package pyroscope_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,7 +27,7 @@ func TestProcessor_ProcessData_Success(t *testing.T) {
 	processor := createProcessor(server.URL, rate.NewLimiter(1000, 1000))
 	profileData := createProfileData()
 
-	err := processor.ProcessData(context.Background(), profileData)
+	_, err := processor.ProcessData(context.Background(), profileData)
 
 	require.NoError(t, err)
 }
@@ -70,7 +74,7 @@ func TestProcessor_ProcessData_RateLimiting(t *testing.T) {
 			start := time.Now()
 
 			for i := 0; i < batchCount; i++ {
-				err := processor.ProcessData(context.Background(), profileData)
+				_, err := processor.ProcessData(context.Background(), profileData)
 				require.NoError(t, err)
 			}
 
@@ -110,6 +114,56 @@ func TestProcessor_ProcessData_RateLimiting(t *testing.T) {
 	}
 }
 
+func TestProcessor_ProcessBatch_ExceedsBurst(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	processor := createProcessor(server.URL, rate.NewLimiter(1000, 64))
+	batch := []pyroscope.TagData{createProfileData(), createProfileData()}
+
+	size, err := processor.ProcessBatch(context.Background(), batch)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds the rate limit burst of 64 bytes")
+	assert.Greater(t, size, 64)
+	assert.Zero(t, requests.Load(), "the batch must not be sent")
+}
+
+func TestProcessor_ProcessData_Compression(t *testing.T) {
+	var mu sync.Mutex
+	var encoding string
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		encoding = r.Header.Get("Content-Encoding")
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := pyroscope.NewClient(server.URL, "", server.Client(), pyroscope.WithCompression(pyroscope.CompressionGzip))
+	processor := pyroscope.NewProcessor(client, pyroscope.NewAppMetadata("test-app", "", 100), rate.NewLimiter(rate.Inf, 0))
+	profileData := createProfileData()
+
+	size, err := processor.ProcessData(context.Background(), profileData)
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "gzip", encoding)
+	assert.Equal(t, len(received), size, "size must be the compressed body size")
+
+	reader, err := gzip.NewReader(bytes.NewReader(received))
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Len(t, body, profileData.Len())
+}
+
 // Helper functions
 
 func createOKServer() *httptest.Server {
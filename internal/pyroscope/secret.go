@@ -0,0 +1,63 @@
+package pyroscope
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+// ReadSecret resolves a secret given as `env:NAME` from an environment variable, as `file:PATH` from a file
+// without the trailing newline, or returns the value as it is.
+func ReadSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := value[len(secretEnvPrefix):]
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s isn't set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretFilePrefix):
+		content, err := os.ReadFile(value[len(secretFilePrefix):])
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
+
+// ParseBasicAuth parses credentials in `user:password` format.
+func ParseBasicAuth(credentials string) (string, string, error) {
+	user, password, found := strings.Cut(credentials, ":")
+	if !found || user == "" {
+		return "", "", fmt.Errorf("invalid basic auth credentials, expected format is user:password")
+	}
+	return user, password, nil
+}
+
+// ParseHeaders parses headers in `Name: value` format, values are resolved with ReadSecret.
+func ParseHeaders(inputs []string) (http.Header, error) {
+	headers := make(http.Header, len(inputs))
+	for _, input := range inputs {
+		name, value, found := strings.Cut(input, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid header `%s`, expected format is Name: value", input)
+		}
+
+		value, err := ReadSecret(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header %s: %w", name, err)
+		}
+		headers.Add(name, value)
+	}
+	return headers, nil
+}
@@ -0,0 +1,71 @@
+package pyroscope_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/pyroscope"
+)
+
+func TestReadSecret(t *testing.T) {
+	t.Setenv("GOSPY_TEST_SECRET", "from-env")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{name: "plain value", value: "plain", want: "plain"},
+		{name: "environment variable", value: "env:GOSPY_TEST_SECRET", want: "from-env"},
+		{name: "file", value: "file:" + secretFile, want: "from-file"},
+		{name: "missing environment variable", value: "env:GOSPY_TEST_MISSING", wantErr: "environment variable GOSPY_TEST_MISSING isn't set"},
+		{name: "missing file", value: "file:" + secretFile + ".missing", wantErr: "failed to read secret: open " + secretFile + ".missing: no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pyroscope.ReadSecret(tt.value)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseBasicAuth(t *testing.T) {
+	user, password, err := pyroscope.ParseBasicAuth("123456:glc_token:with:colons")
+	require.NoError(t, err)
+	assert.Equal(t, "123456", user)
+	assert.Equal(t, "glc_token:with:colons", password)
+
+	_, _, err = pyroscope.ParseBasicAuth("token")
+	assert.EqualError(t, err, "invalid basic auth credentials, expected format is user:password")
+}
+
+func TestParseHeaders(t *testing.T) {
+	t.Setenv("GOSPY_TEST_HEADER", "secret")
+
+	headers, err := pyroscope.ParseHeaders([]string{"X-Team: profiling", "x-api-key: env:GOSPY_TEST_HEADER", "X-Team: php"})
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{
+		"X-Team":    {"profiling", "php"},
+		"X-Api-Key": {"secret"},
+	}, headers)
+
+	_, err = pyroscope.ParseHeaders([]string{"X-Team"})
+	assert.EqualError(t, err, "invalid header `X-Team`, expected format is Name: value")
+
+	_, err = pyroscope.ParseHeaders([]string{"X-Key: env:GOSPY_TEST_MISSING"})
+	assert.EqualError(t, err, "invalid header X-Key: environment variable GOSPY_TEST_MISSING isn't set")
+}
@@ -10,15 +10,17 @@ import (
 
 // StatsAggregator manages statistics collection and reporting
 type StatsAggregator struct {
+	name      string
 	statsChan <-chan *RequestStats
 	interval  time.Duration
 	done      chan struct{}
 	wg        sync.WaitGroup
 }
 
-// NewStatsAggregator creates a new statistics aggregator
-func NewStatsAggregator(statsChan <-chan *RequestStats, interval time.Duration) *StatsAggregator {
+// NewStatsAggregator creates a new statistics aggregator of the named destination
+func NewStatsAggregator(name string, statsChan <-chan *RequestStats, interval time.Duration) *StatsAggregator {
 	return &StatsAggregator{
+		name:      name,
 		statsChan: statsChan,
 		interval:  interval,
 		done:      make(chan struct{}),
@@ -65,6 +67,7 @@ func (sa *StatsAggregator) run(ctx context.Context) {
 		case <-ticker.C:
 			if totalRequests > 0 {
 				log.Info().
+					Str("destination", sa.name).
 					Int("total_requests", totalRequests).
 					Int("total_bytes", totalBytes).
 					Int("success_requests", successRequests).
@@ -0,0 +1,185 @@
+package pyroscope_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/pyroscope"
+)
+
+// writePEM writes a PEM block to a file in dir and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, bytes []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0o600))
+	return path
+}
+
+// newClientCertificate creates a self-signed client certificate and returns it with paths to its cert and key files.
+func newClientCertificate(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gospy"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	return certificate, writePEM(t, dir, "client.crt", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDer)
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("custom CA", func(t *testing.T) {
+		server := httptest.NewTLSServer(okHandler)
+		defer server.Close()
+		caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+		client, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{})
+		require.NoError(t, err)
+		_, err = client.Get(server.URL)
+		require.Error(t, err, "server certificate shouldn't be trusted without the CA bundle")
+
+		client, err = pyroscope.NewHTTPClient(pyroscope.TransportConfig{CAFile: caFile})
+		require.NoError(t, err)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("server name override", func(t *testing.T) {
+		server := httptest.NewTLSServer(okHandler)
+		defer server.Close()
+		caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+		// httptest certificate is issued for example.com
+		client, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{CAFile: caFile, ServerName: "example.com"})
+		require.NoError(t, err)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		client, err = pyroscope.NewHTTPClient(pyroscope.TransportConfig{CAFile: caFile, ServerName: "pyroscope.internal"})
+		require.NoError(t, err)
+		_, err = client.Get(server.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("insecure skip verify", func(t *testing.T) {
+		server := httptest.NewTLSServer(okHandler)
+		defer server.Close()
+
+		client, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("mutual TLS", func(t *testing.T) {
+		clientCertificate, certFile, keyFile := newClientCertificate(t)
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(clientCertificate)
+
+		server := httptest.NewUnstartedServer(okHandler)
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		server.StartTLS()
+		defer server.Close()
+
+		client, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		_, err = client.Get(server.URL)
+		require.Error(t, err, "request without client certificate should be rejected")
+
+		client, err = pyroscope.NewHTTPClient(pyroscope.TransportConfig{
+			InsecureSkipVerify: true,
+			CertFile:           certFile,
+			KeyFile:            keyFile,
+		})
+		require.NoError(t, err)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		var proxiedURL string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxiedURL = r.URL.String()
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer proxy.Close()
+
+		client, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{ProxyURL: proxy.URL})
+		require.NoError(t, err)
+		resp, err := client.Get("http://pyroscope.internal:4040/ingest")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "http://pyroscope.internal:4040/ingest", proxiedURL)
+	})
+
+	t.Run("pool settings", func(t *testing.T) {
+		client, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{
+			Timeout:             5 * time.Second,
+			MaxIdleConns:        10,
+			MaxIdleConnsPerHost: 4,
+			MaxConnsPerHost:     8,
+			IdleConnTimeout:     time.Minute,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 5*time.Second, client.Timeout)
+
+		transport := client.Transport.(*http.Transport)
+		assert.Equal(t, 10, transport.MaxIdleConns)
+		assert.Equal(t, 4, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, 8, transport.MaxConnsPerHost)
+		assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := pyroscope.NewHTTPClient(pyroscope.TransportConfig{CertFile: "client.crt"})
+		assert.EqualError(t, err, "client certificate and key must be set together")
+
+		emptyCA := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(emptyCA, []byte("not a certificate"), 0o600))
+		_, err = pyroscope.NewHTTPClient(pyroscope.TransportConfig{CAFile: emptyCA})
+		assert.EqualError(t, err, "no certificates found in CA bundle "+emptyCA)
+
+		_, err = pyroscope.NewHTTPClient(pyroscope.TransportConfig{ProxyURL: "http://proxy:port"})
+		assert.ErrorContains(t, err, "invalid proxy url")
+	})
+}
@@ -2,8 +2,8 @@ package pyroscope
 
 import (
 	"context"
+	"errors"
 	"sync"
-	"time"
 
 	"github.com/rs/zerolog/log"
 	"golang.org/x/time/rate"
@@ -11,8 +11,6 @@ import (
 	"github.com/hakastein/gospy/internal/collector"
 )
 
-const defaultPollInterval = 100 * time.Millisecond
-
 // RequestStats represents the statistics of a single request.
 type RequestStats struct {
 	Bytes   int
@@ -22,19 +20,32 @@ type RequestStats struct {
 
 // Worker manages to send profile data to the Pyroscope server.
 type Worker struct {
-	processor    *Processor
-	collector    *collector.TraceCollector
+	processor *Processor
+	collector *collector.TraceCollector
+	// batchSize is the maximum size of profile data sent in one batch, batching is disabled if it's zero
+	batchSize int
+	// pending is the profile data that didn't fit into the previous batch
+	pending      *collector.TagCollection
 	statsChannel chan<- *RequestStats
 	done         chan struct{}
 	wg           sync.WaitGroup
 }
 
 // NewWorker initializes and returns a new Worker with a statistics channel.
-func NewWorker(client *Client, appMetadata *AppMetadata, collector *collector.TraceCollector, rateLimiter *rate.Limiter, statsChannel chan<- *RequestStats) *Worker {
+// With a positive batchSize tag groups are combined into pprof requests with up to batchSize bytes of profile data.
+func NewWorker(
+	client *Client,
+	appMetadata *AppMetadata,
+	collector *collector.TraceCollector,
+	rateLimiter *rate.Limiter,
+	batchSize int,
+	statsChannel chan<- *RequestStats,
+) *Worker {
 	processor := NewProcessor(client, appMetadata, rateLimiter)
 	return &Worker{
 		processor:    processor,
 		collector:    collector,
+		batchSize:    batchSize,
 		statsChannel: statsChannel,
 		done:         make(chan struct{}),
 	}
@@ -49,29 +60,25 @@ func (worker *Worker) Start(ctx context.Context) {
 
 		log.Info().Msg("pyroscope worker started")
 
-		for {
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("pyroscope worker shutting down")
-				return
-			default:
-				if !worker.processNext(ctx) {
-					time.Sleep(defaultPollInterval)
-				}
-			}
+		// processNext waits for profile data and returns false once the context is done
+		for worker.processNext(ctx) {
 		}
+		log.Info().Msg("pyroscope worker shutting down")
 	}()
 }
 
 // processNext handles one iteration - simple coordinator logic
 func (worker *Worker) processNext(ctx context.Context) bool {
-	profileData, ok := worker.collector.ConsumeTag()
+	if worker.batchSize > 0 {
+		return worker.processNextBatch(ctx)
+	}
+
+	profileData, ok := worker.collector.ConsumeTagWait(ctx)
 	if !ok {
 		return false
 	}
 
-	dataSize := profileData.Len()
-	err := worker.processor.ProcessData(ctx, profileData)
+	dataSize, err := worker.processor.ProcessData(ctx, profileData)
 
 	// Log the results
 	if err != nil {
@@ -93,3 +100,79 @@ func (worker *Worker) processNext(ctx context.Context) bool {
 	worker.statsChannel <- stats
 	return true
 }
+
+// processNextBatch sends the next batch of tag groups, one request per app and profile type.
+func (worker *Worker) processNextBatch(ctx context.Context) bool {
+	batch := worker.nextBatch(ctx)
+	if len(batch) == 0 {
+		return false
+	}
+
+	for _, group := range GroupBatches(batch) {
+		worker.sendBatch(ctx, group)
+	}
+	return true
+}
+
+// sendBatch sends the batch in one request. The batch size is measured in the folded format, but labels can make
+// the pprof body bigger, so a batch exceeding the rate limit burst is split in halves which are sent separately.
+func (worker *Worker) sendBatch(ctx context.Context, batch []TagData) {
+	dataSize, err := worker.processor.ProcessBatch(ctx, batch)
+
+	if errors.Is(err, ErrExceedsBurst) && len(batch) > 1 {
+		log.Debug().
+			Int("tag_groups", len(batch)).
+			Int("bytes", dataSize).
+			Msg("batch exceeds the rate limit burst, splitting it")
+		half := len(batch) / 2
+		worker.sendBatch(ctx, batch[:half])
+		worker.sendBatch(ctx, batch[half:])
+		return
+	}
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Int("tag_groups", len(batch)).
+			Msg("failed to send batch to Pyroscope")
+	} else {
+		log.Debug().
+			Int("tag_groups", len(batch)).
+			Msg("successfully sent batch to Pyroscope")
+	}
+
+	worker.statsChannel <- &RequestStats{
+		Bytes:   dataSize,
+		Success: err == nil,
+		Error:   err,
+	}
+}
+
+// nextBatch waits for a tag group and consumes more until their size reaches batchSize or the queue is empty.
+// A batch always has at least one tag group, even if it's bigger than batchSize. It's empty once the context is done.
+func (worker *Worker) nextBatch(ctx context.Context) []TagData {
+	var batch []TagData
+	size := 0
+	for {
+		profileData := worker.pending
+		worker.pending = nil
+		if profileData == nil {
+			var ok bool
+			if len(batch) == 0 {
+				profileData, ok = worker.collector.ConsumeTagWait(ctx)
+			} else {
+				profileData, ok = worker.collector.ConsumeTag()
+			}
+			if !ok {
+				return batch
+			}
+		}
+
+		if len(batch) > 0 && size+profileData.Len() > worker.batchSize {
+			worker.pending = profileData
+			return batch
+		}
+		batch = append(batch, profileData)
+		size += profileData.Len()
+	}
+}
@@ -0,0 +1,52 @@
+package pyroscope
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+
+	"github.com/hakastein/gospy/internal/collector"
+)
+
+func TestWorker_Start(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	traces := collector.NewTraceCollector()
+	statsChannel := make(chan *RequestStats, 1)
+	worker := NewWorker(
+		NewClient(server.URL, "", server.Client()),
+		NewAppMetadata("myapp", "", 100),
+		traces,
+		rate.NewLimiter(rate.Inf, 0),
+		0,
+		statsChannel,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	worker.Start(ctx)
+
+	// an idle worker must wake up as soon as there is data
+	time.Sleep(50 * time.Millisecond)
+	traces.AddSample(&collector.Sample{Time: time.Now(), Trace: "main;foo", Tags: "uri=/"})
+	select {
+	case stats := <-statsChannel:
+		assert.True(t, stats.Success)
+	case <-time.After(time.Second):
+		t.Fatal("worker didn't send data")
+	}
+
+	cancel()
+	select {
+	case <-worker.done:
+	case <-time.After(time.Second):
+		t.Fatal("worker didn't shut down")
+	}
+}
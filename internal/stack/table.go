@@ -0,0 +1,180 @@
+package stack
+
+import (
+	"strings"
+	"sync"
+)
+
+// ID identifies a stack in a Table, stacks with the same frames have the same ID.
+// Root is the empty stack, every other stack is its parent stack with one more frame.
+type ID uint32
+
+// Root is the ID of the empty stack.
+const Root ID = 0
+
+// FrameID identifies an interned frame in a Table.
+type FrameID uint32
+
+// node is a stack of the trie, it's the parent stack with the leaf frame pushed.
+type node struct {
+	parent ID
+	frame  FrameID
+}
+
+// Table interns frames and stores stacks as a prefix trie, so shared prefixes and frames are stored once
+// and a stack is a single ID. It's safe for concurrent use. Stacks are never removed, Tables replaces
+// a table that has grown too big with an empty one.
+type Table struct {
+	mu       sync.RWMutex
+	frameIDs map[string]FrameID
+	frames   []string
+	nodeIDs  map[node]ID
+	nodes    []node
+}
+
+// NewTable creates an empty Table.
+func NewTable() *Table {
+	return &Table{
+		frameIDs: make(map[string]FrameID),
+		nodeIDs:  make(map[node]ID),
+		// the root stack has no frame
+		nodes: []node{{}},
+	}
+}
+
+// Intern returns the ID of the stack with the frames, root first, adding the stack if it's new.
+func (table *Table) Intern(frames []string) ID {
+	table.mu.RLock()
+	id, depth := table.lookup(Root, frames)
+	table.mu.RUnlock()
+	if depth == len(frames) {
+		return id
+	}
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	// other goroutines may have added frames since the read lock was released
+	missing := frames[depth:]
+	id, depth = table.lookup(id, missing)
+	for _, frame := range missing[depth:] {
+		id = table.push(id, frame)
+	}
+	return id
+}
+
+// InternFolded returns the ID of the stack in folded format, frames separated by `;`, root first.
+func (table *Table) InternFolded(folded string) ID {
+	if folded == "" {
+		return Root
+	}
+	// most stacks fit into the buffer, so splitting them doesn't allocate
+	var buffer [64]string
+	frames := buffer[:0]
+	for {
+		frame, rest, found := strings.Cut(folded, ";")
+		frames = append(frames, frame)
+		if !found {
+			break
+		}
+		folded = rest
+	}
+	return table.Intern(frames)
+}
+
+// lookup walks the trie from the parent along existing frames and returns the last found stack
+// and the number of frames found. The table must be locked.
+func (table *Table) lookup(parent ID, frames []string) (ID, int) {
+	for i, frame := range frames {
+		frameID, ok := table.frameIDs[frame]
+		if !ok {
+			return parent, i
+		}
+		id, ok := table.nodeIDs[node{parent: parent, frame: frameID}]
+		if !ok {
+			return parent, i
+		}
+		parent = id
+	}
+	return parent, len(frames)
+}
+
+// push adds the stack of the parent with the frame pushed. The table must be write locked.
+func (table *Table) push(parent ID, frame string) ID {
+	frameID, ok := table.frameIDs[frame]
+	if !ok {
+		// frames are often substrings of a bigger string, which shouldn't be kept alive
+		frame = strings.Clone(frame)
+		frameID = FrameID(len(table.frames))
+		table.frames = append(table.frames, frame)
+		table.frameIDs[frame] = frameID
+	}
+
+	n := node{parent: parent, frame: frameID}
+	if id, ok := table.nodeIDs[n]; ok {
+		return id
+	}
+	id := ID(len(table.nodes))
+	table.nodes = append(table.nodes, n)
+	table.nodeIDs[n] = id
+	return id
+}
+
+// Frames returns frames of the stack, root first.
+func (table *Table) Frames(id ID) []string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	depth := 0
+	for current := id; current != Root; current = table.nodes[current].parent {
+		depth++
+	}
+	frames := make([]string, depth)
+	for current := id; current != Root; current = table.nodes[current].parent {
+		depth--
+		frames[depth] = table.frames[table.nodes[current].frame]
+	}
+	return frames
+}
+
+// FrameIDs returns interned frames of the stack, leaf first, Frame returns their names.
+func (table *Table) FrameIDs(id ID) []FrameID {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	var frameIDs []FrameID
+	for current := id; current != Root; current = table.nodes[current].parent {
+		frameIDs = append(frameIDs, table.nodes[current].frame)
+	}
+	return frameIDs
+}
+
+// Frame returns the interned frame.
+func (table *Table) Frame(id FrameID) string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.frames[id]
+}
+
+// Folded returns the stack in folded format, frames separated by `;`, root first.
+func (table *Table) Folded(id ID) string {
+	return strings.Join(table.Frames(id), ";")
+}
+
+// FoldedLen returns the length of the stack in folded format without building it.
+func (table *Table) FoldedLen(id ID) int {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	length := -1
+	for current := id; current != Root; current = table.nodes[current].parent {
+		length += len(table.frames[table.nodes[current].frame]) + 1
+	}
+	return max(length, 0)
+}
+
+// Len returns the number of stacks in the table, not counting the root one.
+func (table *Table) Len() int {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return len(table.nodes) - 1
+}
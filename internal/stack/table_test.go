@@ -0,0 +1,79 @@
+package stack_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/stack"
+)
+
+func TestTable_Intern(t *testing.T) {
+	table := stack.NewTable()
+
+	login := table.Intern([]string{"main", "controller", "login"})
+	logout := table.Intern([]string{"main", "controller", "logout"})
+	controller := table.Intern([]string{"main", "controller"})
+
+	assert.NotEqual(t, login, logout)
+	assert.Equal(t, login, table.Intern([]string{"main", "controller", "login"}), "same frames must have the same id")
+	assert.Equal(t, login, table.InternFolded("main;controller;login"))
+	assert.Equal(t, 4, table.Len(), "shared prefixes must be stored once")
+
+	assert.Equal(t, []string{"main", "controller", "logout"}, table.Frames(logout))
+	assert.Equal(t, "main;controller", table.Folded(controller))
+	assert.Equal(t, len("main;controller;logout"), table.FoldedLen(logout))
+
+	frameIDs := table.FrameIDs(login)
+	require.Len(t, frameIDs, 3)
+	assert.Equal(t, "login", table.Frame(frameIDs[0]), "frame ids must be leaf first")
+	assert.Equal(t, "main", table.Frame(frameIDs[2]))
+
+	assert.Equal(t, stack.Root, table.Intern(nil))
+	assert.Equal(t, stack.Root, table.InternFolded(""))
+	assert.Empty(t, table.Frames(stack.Root))
+	assert.Equal(t, 0, table.FoldedLen(stack.Root))
+}
+
+func TestTable_InternConcurrent(t *testing.T) {
+	table := stack.NewTable()
+
+	var wg sync.WaitGroup
+	ids := make([][]stack.ID, 4)
+	for g := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ids[g] = append(ids[g], table.InternFolded(fmt.Sprintf("main;func%d;leaf%d", i%10, i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for g := range ids {
+		assert.Equal(t, ids[0], ids[g], "all goroutines must get the same ids")
+	}
+	// main, 10 funcs and 100 leaves
+	assert.Equal(t, 111, table.Len())
+	for i, id := range ids[0] {
+		assert.Equal(t, fmt.Sprintf("main;func%d;leaf%d", i%10, i), table.Folded(id))
+	}
+}
+
+func BenchmarkTable_Intern(b *testing.B) {
+	table := stack.NewTable()
+	frames := strings.Split("main;App\\Kernel::handle;App\\Controller::index;App\\Repository::find;PDO::query", ";")
+	table.Intern(frames)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		table.Intern(frames)
+	}
+}
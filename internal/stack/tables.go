@@ -0,0 +1,43 @@
+package stack
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxStacks is the number of stacks a table of Tables holds before it's replaced.
+const DefaultMaxStacks = 1 << 18
+
+// Tables hands out the current Table and replaces it with an empty one once it has maxStacks stacks,
+// so stacks that aren't sampled anymore don't keep memory forever. IDs are only valid in the table they're
+// from, so holders keep the table along with IDs, and a replaced table is freed once nothing refers to it.
+type Tables struct {
+	mu        sync.Mutex
+	current   atomic.Pointer[Table]
+	maxStacks int
+}
+
+// NewTables creates Tables replacing the current table once it has maxStacks stacks.
+func NewTables(maxStacks int) *Tables {
+	tables := &Tables{maxStacks: max(maxStacks, 1)}
+	tables.current.Store(NewTable())
+	return tables
+}
+
+// Current returns the table new stacks are interned in.
+func (tables *Tables) Current() *Table {
+	table := tables.current.Load()
+	if table.Len() < tables.maxStacks {
+		return table
+	}
+
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+	// the table may have been replaced by another goroutine already
+	table = tables.current.Load()
+	if table.Len() >= tables.maxStacks {
+		table = NewTable()
+		tables.current.Store(table)
+	}
+	return table
+}
@@ -0,0 +1,25 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hakastein/gospy/internal/stack"
+)
+
+func TestTables_Current(t *testing.T) {
+	tables := stack.NewTables(3)
+
+	first := tables.Current()
+	login := first.Intern([]string{"main", "login"})
+	assert.Same(t, first, tables.Current(), "the table must be kept until it's full")
+
+	first.Intern([]string{"main", "logout"})
+	second := tables.Current()
+	assert.NotSame(t, first, second, "a full table must be replaced")
+	assert.Equal(t, 0, second.Len())
+	assert.Same(t, second, tables.Current())
+
+	assert.Equal(t, "main;login", first.Folded(login), "stacks of the replaced table must stay readable")
+}
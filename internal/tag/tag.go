@@ -18,10 +18,12 @@ func isTagKeyRuneAllowed(r rune) bool {
 }
 
 // DynamicTag represents a dynamic tag with optional regex and replacement.
+// TagNormalize is an optional function applied to the value after the regex replacement.
 type DynamicTag struct {
-	TagKey     string
-	TagRegexp  *regexp.Regexp
-	TagReplace string
+	TagKey       string
+	TagRegexp    *regexp.Regexp
+	TagReplace   string
+	TagNormalize func(string) string
 }
 
 func (t DynamicTag) GetValue(input string) string {
@@ -29,6 +31,10 @@ func (t DynamicTag) GetValue(input string) string {
 		input = t.TagRegexp.ReplaceAllString(input, t.TagReplace)
 	}
 
+	if t.TagNormalize != nil {
+		input = t.TagNormalize(input)
+	}
+
 	// replace coma to greek coma, it's nasty, but it's work
 	input = strings.ReplaceAll(input, ",", "͵")
 
@@ -2,6 +2,7 @@ package tag_test
 
 import (
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/hakastein/gospy/internal/tag"
@@ -224,6 +225,12 @@ func TestDynamicTag_GetValue(t *testing.T) {
 			input:    "hello,world",
 			expected: "hello͵world",
 		},
+		{
+			name:     "Normalize After Regex",
+			tag:      tag.DynamicTag{TagKey: "method", TagRegexp: regexp.MustCompile("^ +"), TagReplace: "$1", TagNormalize: strings.ToUpper},
+			input:    "  post",
+			expected: "POST",
+		},
 		{
 			name:     "Regex and Comma Replacement",
 			tag:      tag.DynamicTag{TagKey: "test", TagRegexp: regexp.MustCompile("a"), TagReplace: "b"},
@@ -0,0 +1,71 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrStackFiltered is returned for stacks rejected by FoldOptions.Filter.
+var ErrStackFiltered = errors.New("stack is filtered out")
+
+// StackFilter keeps or drops whole stacks based on their frames, like pprof -focus and -ignore.
+type StackFilter struct {
+	focus  []*regexp.Regexp
+	ignore []*regexp.Regexp
+}
+
+// NewStackFilter creates a StackFilter from focus and ignore regular expressions.
+func NewStackFilter(focus, ignore []string) (*StackFilter, error) {
+	focusPatterns, err := compilePatterns("focus", focus)
+	if err != nil {
+		return nil, err
+	}
+	ignorePatterns, err := compilePatterns("ignore", ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StackFilter{focus: focusPatterns, ignore: ignorePatterns}, nil
+}
+
+func compilePatterns(kind string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern `%s`: %v", kind, pattern, err)
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled, nil
+}
+
+// Keep reports whether a stack should be kept: no frame matches an ignore pattern
+// and, if there are focus patterns, at least one frame matches one of them.
+func (filter *StackFilter) Keep(frames []string) bool {
+	if filter == nil {
+		return true
+	}
+
+	focused := len(filter.focus) == 0
+	for _, frame := range frames {
+		if anyMatch(filter.ignore, frame) {
+			return false
+		}
+		if !focused && anyMatch(filter.focus, frame) {
+			focused = true
+		}
+	}
+
+	return focused
+}
+
+func anyMatch(patterns []*regexp.Regexp, frame string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(frame) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package transform_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/transform"
+)
+
+func TestStackFilter_Keep(t *testing.T) {
+	tests := []struct {
+		name   string
+		focus  []string
+		ignore []string
+		frames []string
+		want   bool
+	}{
+		{
+			name:   "no patterns",
+			frames: []string{"main", "App::run"},
+			want:   true,
+		},
+		{
+			name:   "focus matches a frame",
+			focus:  []string{`^Doctrine\\ORM\\`, "^curl_exec$"},
+			frames: []string{"main", "App::run", "curl_exec"},
+			want:   true,
+		},
+		{
+			name:   "focus matches no frame",
+			focus:  []string{`^Doctrine\\ORM\\`},
+			frames: []string{"main", "App::run", "curl_exec"},
+			want:   false,
+		},
+		{
+			name:   "ignore matches a frame",
+			ignore: []string{"HealthController"},
+			frames: []string{"main", "HealthController::check"},
+			want:   false,
+		},
+		{
+			name:   "ignore wins over focus",
+			focus:  []string{"^curl_exec$"},
+			ignore: []string{"HealthController"},
+			frames: []string{"main", "HealthController::check", "curl_exec"},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := transform.NewStackFilter(tt.focus, tt.ignore)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, filter.Keep(tt.frames))
+		})
+	}
+}
+
+func TestNewStackFilter_Errors(t *testing.T) {
+	_, err := transform.NewStackFilter([]string{"("}, nil)
+	assert.EqualError(t, err, "invalid focus pattern `(`: error parsing regexp: missing closing ): `(`")
+
+	_, err = transform.NewStackFilter(nil, []string{"["})
+	assert.EqualError(t, err, "invalid ignore pattern `[`: error parsing regexp: missing closing ]: `[`")
+}
@@ -0,0 +1,105 @@
+package transform
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathAlias maps a path prefix to its canonical form, like a symlink pointing to a release directory.
+type pathAlias struct {
+	from string
+	to   string
+}
+
+// pathRewrite replaces matches of a pattern in paths.
+type pathRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// PathNormalizer rewrites file paths of traces, so they don't change between deploys.
+type PathNormalizer struct {
+	aliases       []pathAlias
+	rewrites      []pathRewrite
+	stripPrefixes []string
+}
+
+// NewPathNormalizer creates a PathNormalizer.
+// Aliases are in `from=to` format and replace the `from` path prefix with `to`.
+// Rewrites are in `pattern=>replacement` format, pattern is a regular expression.
+// Strip prefixes are removed from the beginning of paths.
+func NewPathNormalizer(stripPrefixes, rewrites, aliases []string) (*PathNormalizer, error) {
+	normalizer := &PathNormalizer{stripPrefixes: stripPrefixes}
+
+	for _, alias := range aliases {
+		idx := strings.Index(alias, "=")
+		if idx <= 0 || idx == len(alias)-1 {
+			return nil, fmt.Errorf("invalid path alias `%s`, expected format is from=to", alias)
+		}
+		normalizer.aliases = append(normalizer.aliases, pathAlias{
+			from: strings.TrimSuffix(alias[:idx], "/"),
+			to:   strings.TrimSuffix(alias[idx+1:], "/"),
+		})
+	}
+
+	for _, rewrite := range rewrites {
+		idx := strings.LastIndex(rewrite, frameRuleReplaceSeparator)
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid path rewrite `%s`, expected format is pattern=>replacement", rewrite)
+		}
+		regex, err := regexp.Compile(rewrite[:idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in path rewrite `%s`: %v", rewrite, err)
+		}
+		normalizer.rewrites = append(normalizer.rewrites, pathRewrite{
+			pattern:     regex,
+			replacement: rewrite[idx+len(frameRuleReplaceSeparator):],
+		})
+	}
+
+	return normalizer, nil
+}
+
+// Normalize cleans the path, then replaces the first matching alias, applies all rewrites
+// and strips the first matching prefix.
+func (normalizer *PathNormalizer) Normalize(filePath string) string {
+	if normalizer == nil || !hasSourceLine(filePath) {
+		return filePath
+	}
+
+	filePath = path.Clean(filePath)
+
+	for _, alias := range normalizer.aliases {
+		if filePath == alias.from || strings.HasPrefix(filePath, alias.from+"/") {
+			filePath = alias.to + filePath[len(alias.from):]
+			break
+		}
+	}
+
+	for _, rewrite := range normalizer.rewrites {
+		filePath = rewrite.pattern.ReplaceAllString(filePath, rewrite.replacement)
+	}
+
+	for _, prefix := range normalizer.stripPrefixes {
+		if strings.HasPrefix(filePath, prefix) {
+			filePath = filePath[len(prefix):]
+			break
+		}
+	}
+
+	return filePath
+}
+
+// normalizeFileInfo normalizes the path of `path:line` file info.
+func (normalizer *PathNormalizer) normalizeFileInfo(fileInfo string) string {
+	if normalizer == nil {
+		return fileInfo
+	}
+	colonIdx := strings.LastIndex(fileInfo, ":")
+	if colonIdx == -1 {
+		return normalizer.Normalize(fileInfo)
+	}
+	return normalizer.Normalize(fileInfo[:colonIdx]) + fileInfo[colonIdx:]
+}
@@ -0,0 +1,84 @@
+package transform_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/transform"
+)
+
+func TestPathNormalizer_Normalize(t *testing.T) {
+	tests := []struct {
+		name          string
+		stripPrefixes []string
+		rewrites      []string
+		aliases       []string
+		path          string
+		want          string
+	}{
+		{
+			name: "no rules cleans the path",
+			path: "/var/www/./public/../index.php",
+			want: "/var/www/index.php",
+		},
+		{
+			name:          "strip prefix",
+			stripPrefixes: []string{"/srv/app/", "/var/www/"},
+			path:          "/var/www/public/index.php",
+			want:          "public/index.php",
+		},
+		{
+			name:     "rewrite release directory",
+			rewrites: []string{`^/var/www/releases/[^/]+/=>/var/www/current/`},
+			path:     "/var/www/releases/20261015-1234/public/index.php",
+			want:     "/var/www/current/public/index.php",
+		},
+		{
+			name:    "alias replaces the prefix on a path boundary",
+			aliases: []string{"/mnt/deploy/app=/var/www/current"},
+			path:    "/mnt/deploy/app/public/index.php",
+			want:    "/var/www/current/public/index.php",
+		},
+		{
+			name:    "alias doesn't match a partial directory name",
+			aliases: []string{"/mnt/deploy/app=/var/www/current"},
+			path:    "/mnt/deploy/application/index.php",
+			want:    "/mnt/deploy/application/index.php",
+		},
+		{
+			name:          "alias, rewrite and strip prefix are applied in order",
+			aliases:       []string{"/mnt/releases/=/var/www/releases/"},
+			rewrites:      []string{`^/var/www/releases/\d{8}-\d{4}=>/var/www/current`},
+			stripPrefixes: []string{"/var/www/current"},
+			path:          "/mnt/releases/20261015-1234/public/index.php",
+			want:          "/public/index.php",
+		},
+		{
+			name:          "internal functions are kept",
+			stripPrefixes: []string{"<"},
+			path:          "<internal>",
+			want:          "<internal>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizer, err := transform.NewPathNormalizer(tt.stripPrefixes, tt.rewrites, tt.aliases)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, normalizer.Normalize(tt.path))
+		})
+	}
+}
+
+func TestNewPathNormalizer_Errors(t *testing.T) {
+	_, err := transform.NewPathNormalizer(nil, []string{"/var/www"}, nil)
+	assert.EqualError(t, err, "invalid path rewrite `/var/www`, expected format is pattern=>replacement")
+
+	_, err = transform.NewPathNormalizer(nil, []string{"(=>x"}, nil)
+	assert.EqualError(t, err, "invalid pattern in path rewrite `(=>x`: error parsing regexp: missing closing ): `(`")
+
+	_, err = transform.NewPathNormalizer(nil, nil, []string{"/var/www"})
+	assert.EqualError(t, err, "invalid path alias `/var/www`, expected format is from=to")
+}
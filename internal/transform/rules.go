@@ -0,0 +1,161 @@
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FrameRuleKind is the action a FrameRule applies to matching frames.
+type FrameRuleKind int
+
+const (
+	// RuleTruncateAbove drops frames called by the first matching frame, the marker becomes the leaf.
+	RuleTruncateAbove FrameRuleKind = iota
+	// RuleTruncateBelow drops callers of the last matching frame, the marker becomes the root.
+	RuleTruncateBelow
+	// RuleDrop removes matching frames.
+	RuleDrop
+	// RuleRename replaces matches of the pattern in a frame with the replacement.
+	RuleRename
+	// RuleCollapse merges consecutive matching frames into one frame.
+	RuleCollapse
+)
+
+var frameRuleKinds = map[string]FrameRuleKind{
+	"truncate-above": RuleTruncateAbove,
+	"truncate-below": RuleTruncateBelow,
+	"drop":           RuleDrop,
+	"rename":         RuleRename,
+	"collapse":       RuleCollapse,
+}
+
+// frameRuleReplaceSeparator separates the pattern and the replacement in rename and collapse rules.
+const frameRuleReplaceSeparator = "=>"
+
+// FrameRule rewrites or filters frames of a stack.
+type FrameRule struct {
+	Kind        FrameRuleKind
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseFrameRule parses a rule in `kind:pattern` or `kind:pattern=>replacement` format.
+// Kind is one of truncate-above, truncate-below, drop, rename or collapse, pattern is a regular expression.
+// A collapse rule replaces each collapsed run with the expanded replacement, e.g. `$1`,
+// or keeps the first frame of the run if there's no replacement.
+func ParseFrameRule(input string) (FrameRule, error) {
+	idx := strings.Index(input, ":")
+	if idx == -1 {
+		return FrameRule{}, fmt.Errorf("invalid frame rule `%s`, expected format is kind:pattern", input)
+	}
+
+	kind, ok := frameRuleKinds[input[:idx]]
+	if !ok {
+		return FrameRule{}, fmt.Errorf("unknown frame rule kind `%s`", input[:idx])
+	}
+
+	pattern, replacement := input[idx+1:], ""
+	hasReplacement := false
+	if sep := strings.LastIndex(pattern, frameRuleReplaceSeparator); sep != -1 {
+		pattern, replacement = pattern[:sep], pattern[sep+len(frameRuleReplaceSeparator):]
+		hasReplacement = true
+	}
+
+	switch {
+	case kind == RuleRename && !hasReplacement:
+		return FrameRule{}, fmt.Errorf("rename rule `%s` requires a replacement, expected format is rename:pattern=>replacement", input)
+	case hasReplacement && kind != RuleRename && kind != RuleCollapse:
+		return FrameRule{}, fmt.Errorf("frame rule `%s` doesn't support a replacement", input)
+	case pattern == "":
+		return FrameRule{}, fmt.Errorf("frame rule `%s` has an empty pattern", input)
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return FrameRule{}, fmt.Errorf("invalid pattern in frame rule `%s`: %v", input, err)
+	}
+
+	return FrameRule{Kind: kind, Pattern: regex, Replacement: replacement}, nil
+}
+
+// FrameRules is a set of frame rules applied to stacks before aggregation.
+type FrameRules []FrameRule
+
+// ParseFrameRules parses each input with ParseFrameRule.
+func ParseFrameRules(inputs []string) (FrameRules, error) {
+	rules := make(FrameRules, 0, len(inputs))
+	for _, input := range inputs {
+		rule, err := ParseFrameRule(input)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Apply rewrites frames ordered from root to leaf and returns the result, frames may be modified in place.
+// Rules are applied by kind in a fixed order regardless of the order they're given in:
+// truncation first, so markers match the original frames, then drop, rename and collapse.
+// Rules of the same kind are applied in the given order.
+func (rules FrameRules) Apply(frames []string) []string {
+	for _, kind := range []FrameRuleKind{RuleTruncateAbove, RuleTruncateBelow, RuleDrop, RuleRename, RuleCollapse} {
+		for _, rule := range rules {
+			if rule.Kind == kind {
+				frames = rule.apply(frames)
+			}
+		}
+	}
+	return frames
+}
+
+func (rule FrameRule) apply(frames []string) []string {
+	switch rule.Kind {
+	case RuleTruncateAbove:
+		for i, frame := range frames {
+			if rule.Pattern.MatchString(frame) {
+				return frames[:i+1]
+			}
+		}
+	case RuleTruncateBelow:
+		for i := len(frames) - 1; i >= 0; i-- {
+			if rule.Pattern.MatchString(frames[i]) {
+				return frames[i:]
+			}
+		}
+	case RuleDrop:
+		kept := frames[:0]
+		for _, frame := range frames {
+			if !rule.Pattern.MatchString(frame) {
+				kept = append(kept, frame)
+			}
+		}
+		return kept
+	case RuleRename:
+		for i, frame := range frames {
+			frames[i] = rule.Pattern.ReplaceAllString(frame, rule.Replacement)
+		}
+	case RuleCollapse:
+		kept := frames[:0]
+		collapsing := false
+		for _, frame := range frames {
+			if !rule.Pattern.MatchString(frame) {
+				kept = append(kept, frame)
+				collapsing = false
+				continue
+			}
+			if collapsing {
+				continue
+			}
+			if rule.Replacement != "" {
+				match := rule.Pattern.FindStringSubmatchIndex(frame)
+				frame = string(rule.Pattern.ExpandString(nil, rule.Replacement, frame, match))
+			}
+			kept = append(kept, frame)
+			collapsing = true
+		}
+		return kept
+	}
+	return frames
+}
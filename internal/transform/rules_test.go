@@ -0,0 +1,119 @@
+package transform_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hakastein/gospy/internal/transform"
+)
+
+func TestParseFrameRule(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		kind        transform.FrameRuleKind
+		pattern     string
+		replacement string
+		wantErr     string
+	}{
+		{name: "drop", input: `drop:^Tracer\\`, kind: transform.RuleDrop, pattern: `^Tracer\\`},
+		{name: "rename", input: `rename:\{closure\}#\d+=>{closure}`, kind: transform.RuleRename, pattern: `\{closure\}#\d+`, replacement: "{closure}"},
+		{name: "rename to empty string", input: `rename:^App\\=>`, kind: transform.RuleRename, pattern: `^App\\`},
+		{name: "collapse with name", input: `collapse:^(Illuminate\\\w+)=>$1`, kind: transform.RuleCollapse, pattern: `^(Illuminate\\\w+)`, replacement: "$1"},
+		{name: "truncate above", input: "truncate-above:^PDO::", kind: transform.RuleTruncateAbove, pattern: "^PDO::"},
+		{name: "truncate below", input: "truncate-below:Controller::", kind: transform.RuleTruncateBelow, pattern: "Controller::"},
+		{name: "missing kind", input: "Controller", wantErr: "invalid frame rule `Controller`, expected format is kind:pattern"},
+		{name: "unknown kind", input: "keep:Controller", wantErr: "unknown frame rule kind `keep`"},
+		{name: "rename without replacement", input: "rename:foo", wantErr: "rename rule `rename:foo` requires a replacement, expected format is rename:pattern=>replacement"},
+		{name: "drop with replacement", input: "drop:foo=>bar", wantErr: "frame rule `drop:foo=>bar` doesn't support a replacement"},
+		{name: "empty pattern", input: "drop:", wantErr: "frame rule `drop:` has an empty pattern"},
+		{name: "invalid pattern", input: "drop:(", wantErr: "invalid pattern in frame rule `drop:(`: error parsing regexp: missing closing ): `(`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := transform.ParseFrameRule(tt.input)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.kind, rule.Kind)
+			assert.Equal(t, tt.pattern, rule.Pattern.String())
+			assert.Equal(t, tt.replacement, rule.Replacement)
+		})
+	}
+}
+
+func TestFrameRules_Apply(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []string
+		frames []string
+		want   []string
+	}{
+		{
+			name:   "rename closures",
+			rules:  []string{`rename:\{closure\}#\d+=>{closure}`},
+			frames: []string{"main", "App\\{closure}#12", "App\\{closure}#7"},
+			want:   []string{"main", "App\\{closure}", "App\\{closure}"},
+		},
+		{
+			name:   "drop instrumentation wrappers",
+			rules:  []string{`drop:^Tracer\\`},
+			frames: []string{"main", "Tracer\\Hook::wrap", "App::run", "Tracer\\Hook::wrap", "PDO::query"},
+			want:   []string{"main", "App::run", "PDO::query"},
+		},
+		{
+			name:   "collapse consecutive vendor frames keeping the first one",
+			rules:  []string{`collapse:^Illuminate\\`},
+			frames: []string{"main", "Illuminate\\Kernel::handle", "Illuminate\\Pipeline::then", "App::run", "Illuminate\\DB::select"},
+			want:   []string{"main", "Illuminate\\Kernel::handle", "App::run", "Illuminate\\DB::select"},
+		},
+		{
+			name:   "collapse with replacement",
+			rules:  []string{`collapse:^(Illuminate)\\=>$1`},
+			frames: []string{"main", "Illuminate\\Kernel::handle", "Illuminate\\Pipeline::then", "App::run"},
+			want:   []string{"main", "Illuminate", "App::run"},
+		},
+		{
+			name:   "truncate above marker",
+			rules:  []string{"truncate-above:^PDO::"},
+			frames: []string{"main", "App::run", "PDO::query", "PDOStatement::execute"},
+			want:   []string{"main", "App::run", "PDO::query"},
+		},
+		{
+			name:   "truncate below the deepest marker",
+			rules:  []string{"truncate-below:Middleware::handle"},
+			frames: []string{"main", "Auth\\Middleware::handle", "Cors\\Middleware::handle", "App::run"},
+			want:   []string{"Cors\\Middleware::handle", "App::run"},
+		},
+		{
+			name:   "no marker keeps the stack",
+			rules:  []string{"truncate-above:^PDO::", "truncate-below:Controller"},
+			frames: []string{"main", "App::run"},
+			want:   []string{"main", "App::run"},
+		},
+		{
+			name: "rules are applied in a fixed order",
+			rules: []string{
+				`collapse:^\{closure\}$`,
+				`rename:^App\\\{closure\}#\d+$=>{closure}`,
+				`drop:^Tracer\\`,
+				"truncate-below:^App::run$",
+			},
+			frames: []string{"main", "App::run", "App\\{closure}#1", "Tracer\\Hook::wrap", "App\\{closure}#2", "App::query"},
+			want:   []string{"App::run", "{closure}", "App::query"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := transform.ParseFrameRules(tt.rules)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rules.Apply(tt.frames))
+		})
+	}
+}
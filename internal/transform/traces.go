@@ -2,50 +2,174 @@ package transform
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// LineMode controls whether file and line information is kept in folded stacks.
+type LineMode int
+
+const (
+	// LinesNone keeps function names only.
+	LinesNone LineMode = iota
+	// LinesFrame appends file and line to every frame, e.g. "App::run /app/App.php:42".
+	LinesFrame
+	// LinesLeaf adds the file and line being executed as an extra leaf frame, e.g. "App::run;/app/App.php:42".
+	LinesLeaf
+)
+
+// ParseLineMode converts a line mode name (none, frame or leaf) to LineMode.
+func ParseLineMode(name string) (LineMode, error) {
+	switch name {
+	case "", "none":
+		return LinesNone, nil
+	case "frame":
+		return LinesFrame, nil
+	case "leaf":
+		return LinesLeaf, nil
+	default:
+		return LinesNone, fmt.Errorf("invalid line mode: %s", name)
+	}
+}
+
+// FoldOptions configures how traces are converted to folded stacks.
+type FoldOptions struct {
+	// KeepEntrypointName appends the entry point path to the root frame.
+	KeepEntrypointName bool
+	Lines              LineMode
+	// Rules rewrite and filter frames before they're folded.
+	Rules FrameRules
+	// MaxDepth limits stacks to the root-most frames after the rules are applied, zero means no limit.
+	MaxDepth int
+	// Filter keeps or drops whole stacks after the rules are applied, nil keeps all stacks.
+	Filter *StackFilter
+	// Paths normalizes the entry point and file paths kept in frames, nil keeps paths as they are.
+	Paths *PathNormalizer
+}
+
+// TruncatedFrame replaces frames cut off by FoldOptions.MaxDepth.
+const TruncatedFrame = "[truncated]"
+
+// errEmptyStack is returned when frame rules remove every frame of a stack.
+var errEmptyStack = errors.New("stack is empty after applying frame rules")
+
 // TracesToFoldedStacks converts trace lines to folded stack format and extracts the entry point.
-func TracesToFoldedStacks(trace []string, keepEntrypointName bool) (string, string, error) {
+// It also reports whether the stack was truncated to FoldOptions.MaxDepth.
+// Stacks rejected by FoldOptions.Filter return ErrStackFiltered.
+func TracesToFoldedStacks(trace []string, options FoldOptions) (string, string, bool, error) {
+	frames, entryPoint, truncated, err := TracesToFrames(trace, options)
+	if err != nil {
+		return "", "", false, err
+	}
+	return strings.Join(frames, ";"), entryPoint, truncated, nil
+}
+
+// TracesToFrames is like TracesToFoldedStacks, but returns frames of the stack, root first, instead of joining them.
+func TracesToFrames(trace []string, options FoldOptions) ([]string, string, bool, error) {
+	return AppendFrames(make([]string, 0, len(trace)+1), trace, options)
+}
+
+// AppendFrames is like TracesToFrames, but appends frames to the given slice, so it can be reused between traces.
+// Frames are substrings of the trace lines, only entry point names and file infos kept in frames are allocated.
+func AppendFrames(frames []string, trace []string, options FoldOptions) ([]string, string, bool, error) {
 	if len(trace) < 2 {
-		return "", "", errors.New("trace insufficient length")
+		return nil, "", false, errors.New("trace insufficient length")
 	}
 
-	var (
-		foldedStack strings.Builder
-		entryPoint  string
-	)
+	var entryPoint string
 
 	lastIndex := len(trace) - 1
 	for i := lastIndex; i >= 0; i-- {
-		tokens := strings.Fields(trace[i])
-		// 0 - number of trace
-		// 1 - function
-		// 2 - path with line number
-		if len(tokens) < 3 {
-			return "", "", errors.New("invalid trace format")
+		frame, fileInfo, ok := traceFields(trace[i])
+		if !ok {
+			return nil, "", false, errors.New("invalid trace format")
 		}
 
-		foldedStack.WriteString(tokens[1])
-
 		// Last line in trace is entry point
 		if i == lastIndex {
-			fileInfo := tokens[2]
 			colonIdx := strings.LastIndex(fileInfo, ":")
 			if colonIdx == -1 {
-				return "", "", errors.New("invalid file info in trace")
+				return nil, "", false, errors.New("invalid file info in trace")
 			}
-			entryPoint = fileInfo[:colonIdx]
-			if keepEntrypointName {
-				foldedStack.WriteString(" ")
-				foldedStack.WriteString(entryPoint)
+			entryPoint = options.Paths.Normalize(fileInfo[:colonIdx])
+			if options.KeepEntrypointName && options.Lines != LinesFrame {
+				frame += " " + entryPoint
 			}
 		}
 
-		if i > 0 {
-			foldedStack.WriteString(";")
+		if options.Lines == LinesFrame && hasSourceLine(fileInfo) {
+			frame += " " + options.Paths.normalizeFileInfo(fileInfo)
+		}
+
+		frames = append(frames, frame)
+
+		if i == 0 && options.Lines == LinesLeaf && hasSourceLine(fileInfo) {
+			frames = append(frames, options.Paths.normalizeFileInfo(fileInfo))
+		}
+	}
+
+	if len(options.Rules) > 0 {
+		frames = options.Rules.Apply(frames)
+		if len(frames) == 0 {
+			return nil, "", false, errEmptyStack
+		}
+	}
+
+	if !options.Filter.Keep(frames) {
+		return nil, "", false, ErrStackFiltered
+	}
+
+	truncated := options.MaxDepth > 0 && len(frames) > options.MaxDepth
+	if truncated {
+		frames = append(frames[:options.MaxDepth], TruncatedFrame)
+	}
+
+	return frames, entryPoint, truncated, nil
+}
+
+// traceFields returns the function and the file info of a trace line in `depth function file:line` format.
+// Fields are split like strings.Fields does, but without allocating them, extra fields are ignored.
+func traceFields(line string) (string, string, bool) {
+	_, line = nextField(line)
+	function, line := nextField(line)
+	fileInfo, _ := nextField(line)
+	return function, fileInfo, fileInfo != ""
+}
+
+// asciiSpace is the ASCII whitespace strings.Fields splits fields on.
+var asciiSpace = [utf8.RuneSelf]bool{'\t': true, '\n': true, '\v': true, '\f': true, '\r': true, ' ': true}
+
+// nextField returns the first whitespace separated field of s and the rest of s after it.
+func nextField(s string) (string, string) {
+	start := 0
+	for start < len(s) && s[start] < utf8.RuneSelf && asciiSpace[s[start]] {
+		start++
+	}
+	for end := start; end < len(s); end++ {
+		if s[end] >= utf8.RuneSelf {
+			// non-ASCII lines are rare, they're split on any unicode whitespace
+			return nextUnicodeField(s[start:])
+		}
+		if asciiSpace[s[end]] {
+			return s[start:end], s[end:]
 		}
 	}
+	return s[start:], ""
+}
+
+// nextUnicodeField is nextField for strings with non-ASCII characters.
+func nextUnicodeField(s string) (string, string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end == -1 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
 
-	return foldedStack.String(), entryPoint, nil
+// hasSourceLine reports whether the file info points to PHP source, internal functions are reported as <internal>:-1.
+func hasSourceLine(fileInfo string) bool {
+	return !strings.HasPrefix(fileInfo, "<")
 }
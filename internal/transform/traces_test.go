@@ -2,6 +2,7 @@ package transform_test
 
 import (
 	"errors"
+	"fmt"
 	"github.com/hakastein/gospy/internal/transform"
 	"testing"
 
@@ -13,8 +14,14 @@ type tracesToFoldedStacksTest struct {
 	name               string
 	trace              []string
 	keepEntrypointName bool
+	lines              transform.LineMode
+	rules              []string
+	maxDepth           int
+	stripPrefixes      []string
+	focus              []string
 	wantFoldedStack    string
 	wantEntryPoint     string
+	wantTruncated      bool
 	wantErr            error
 }
 
@@ -22,7 +29,23 @@ type tracesToFoldedStacksTest struct {
 func runTracesToFoldedStacksTests(t *testing.T, tests []tracesToFoldedStacksTest) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotFoldedStack, gotEntryPoint, gotErr := transform.TracesToFoldedStacks(tt.trace, tt.keepEntrypointName)
+			rules, err := transform.ParseFrameRules(tt.rules)
+			assert.NoError(t, err)
+			filter, err := transform.NewStackFilter(tt.focus, nil)
+			assert.NoError(t, err)
+			var paths *transform.PathNormalizer
+			if len(tt.stripPrefixes) > 0 {
+				paths, err = transform.NewPathNormalizer(tt.stripPrefixes, nil, nil)
+				assert.NoError(t, err)
+			}
+			gotFoldedStack, gotEntryPoint, gotTruncated, gotErr := transform.TracesToFoldedStacks(tt.trace, transform.FoldOptions{
+				KeepEntrypointName: tt.keepEntrypointName,
+				Lines:              tt.lines,
+				Rules:              rules,
+				MaxDepth:           tt.maxDepth,
+				Filter:             filter,
+				Paths:              paths,
+			})
 			if tt.wantErr != nil {
 				assert.Error(t, gotErr, "Expected an error but got none")
 				assert.EqualError(t, gotErr, tt.wantErr.Error(), "Error message should match")
@@ -30,6 +53,7 @@ func runTracesToFoldedStacksTests(t *testing.T, tests []tracesToFoldedStacksTest
 				assert.NoError(t, gotErr, "Did not expect an error but got one")
 				assert.Equal(t, tt.wantFoldedStack, gotFoldedStack, "Folded stack should match the expected value")
 				assert.Equal(t, tt.wantEntryPoint, gotEntryPoint, "Entry point should match the expected value")
+				assert.Equal(t, tt.wantTruncated, gotTruncated, "Truncation should match the expected value")
 			}
 		})
 	}
@@ -66,6 +90,134 @@ func TestTracesToFoldedStacks(t *testing.T) {
 		},
 	}
 
+	lineInputs := []tracesToFoldedStacksTest{
+		{
+			name: "Lines in every frame",
+			trace: []string{
+				"0 InitFunction <internal>:-1",
+				"1 ServiceModule::HandleRequest /app/src/ServiceModule.php:45",
+				"2 ServiceModule::Process /app/src/ServiceModule.php:30",
+				"3 Utils::Helper /app/src/Utils.php:15",
+			},
+			keepEntrypointName: true,
+			lines:              transform.LinesFrame,
+			wantFoldedStack: "Utils::Helper /app/src/Utils.php:15;ServiceModule::Process /app/src/ServiceModule.php:30;" +
+				"ServiceModule::HandleRequest /app/src/ServiceModule.php:45;InitFunction",
+			wantEntryPoint: "/app/src/Utils.php",
+		},
+		{
+			name: "Line as leaf frame",
+			trace: []string{
+				"0 ServiceModule::HandleRequest /app/src/ServiceModule.php:45",
+				"1 Utils::Helper /app/src/Utils.php:15",
+			},
+			keepEntrypointName: true,
+			lines:              transform.LinesLeaf,
+			wantFoldedStack:    "Utils::Helper /app/src/Utils.php;ServiceModule::HandleRequest;/app/src/ServiceModule.php:45",
+			wantEntryPoint:     "/app/src/Utils.php",
+		},
+		{
+			name: "Leaf frame is skipped for internal functions",
+			trace: []string{
+				"0 InitFunction <internal>:-1",
+				"1 Utils::Helper /app/src/Utils.php:15",
+			},
+			lines:           transform.LinesLeaf,
+			wantFoldedStack: "Utils::Helper;InitFunction",
+			wantEntryPoint:  "/app/src/Utils.php",
+		},
+	}
+
+	ruleInputs := []tracesToFoldedStacksTest{
+		{
+			name: "Frame rules",
+			trace: []string{
+				"0 PDO::query <internal>:-1",
+				"1 App\\{closure}#3 /app/src/App.php:20",
+				"2 Middleware::handle /app/vendor/Middleware.php:10",
+				"3 main /app/index.php:3",
+			},
+			keepEntrypointName: true,
+			rules:              []string{"drop:^Middleware::", `rename:\{closure\}#\d+=>{closure}`},
+			wantFoldedStack:    "main /app/index.php;App\\{closure};PDO::query",
+			wantEntryPoint:     "/app/index.php",
+		},
+		{
+			name: "Frame rules matching file and line",
+			trace: []string{
+				"0 App::query /app/src/App.php:20",
+				"1 main /app/index.php:3",
+			},
+			lines:           transform.LinesLeaf,
+			rules:           []string{`drop:^/app/src/`},
+			wantFoldedStack: "main;App::query",
+			wantEntryPoint:  "/app/index.php",
+		},
+	}
+
+	depthInputs := []tracesToFoldedStacksTest{
+		{
+			name: "Stack deeper than max depth",
+			trace: []string{
+				"0 Recursive::walk /app/src/Recursive.php:10",
+				"1 Recursive::walk /app/src/Recursive.php:10",
+				"2 Recursive::walk /app/src/Recursive.php:10",
+				"3 main /app/index.php:3",
+			},
+			maxDepth:        2,
+			wantFoldedStack: "main;Recursive::walk;[truncated]",
+			wantEntryPoint:  "/app/index.php",
+			wantTruncated:   true,
+		},
+		{
+			name: "Stack within max depth",
+			trace: []string{
+				"0 Recursive::walk /app/src/Recursive.php:10",
+				"1 main /app/index.php:3",
+			},
+			maxDepth:        2,
+			wantFoldedStack: "main;Recursive::walk",
+			wantEntryPoint:  "/app/index.php",
+		},
+		{
+			name: "Max depth applies after frame rules",
+			trace: []string{
+				"0 Recursive::walk /app/src/Recursive.php:10",
+				"1 Middleware::handle /app/vendor/Middleware.php:10",
+				"2 main /app/index.php:3",
+			},
+			rules:           []string{"drop:^Middleware::"},
+			maxDepth:        2,
+			wantFoldedStack: "main;Recursive::walk",
+			wantEntryPoint:  "/app/index.php",
+		},
+	}
+
+	pathInputs := []tracesToFoldedStacksTest{
+		{
+			name: "Normalized entrypoint name",
+			trace: []string{
+				"0 Helper::run /var/www/releases/1/src/Helper.php:45",
+				"1 main /var/www/releases/1/public/index.php:1",
+			},
+			keepEntrypointName: true,
+			stripPrefixes:      []string{"/var/www/releases/1"},
+			wantFoldedStack:    "main /public/index.php;Helper::run",
+			wantEntryPoint:     "/public/index.php",
+		},
+		{
+			name: "Normalized paths in frames",
+			trace: []string{
+				"0 Helper::run /var/www/releases/1/src/Helper.php:45",
+				"1 main /var/www/releases/1/public/index.php:1",
+			},
+			lines:           transform.LinesFrame,
+			stripPrefixes:   []string{"/var/www/releases/1"},
+			wantFoldedStack: "main /public/index.php:1;Helper::run /src/Helper.php:45",
+			wantEntryPoint:  "/public/index.php",
+		},
+	}
+
 	invalidInputs := []tracesToFoldedStacksTest{
 		{
 			name: "Trace with Insufficient Length",
@@ -110,13 +262,113 @@ func TestTracesToFoldedStacks(t *testing.T) {
 			wantEntryPoint:     "",
 			wantErr:            errors.New("invalid trace format"),
 		},
+		{
+			name: "Trace with Every Frame Dropped",
+			trace: []string{
+				"0 Helper::run /app/src/Helper.php:45",
+				"1 main /app/index.php:1",
+			},
+			rules:   []string{"drop:."},
+			wantErr: errors.New("stack is empty after applying frame rules"),
+		},
+		{
+			name: "Trace without Focused Frames",
+			trace: []string{
+				"0 Helper::run /app/src/Helper.php:45",
+				"1 main /app/index.php:1",
+			},
+			focus:   []string{"^curl_exec$"},
+			wantErr: transform.ErrStackFiltered,
+		},
 	}
 
 	t.Run("Valid Inputs", func(t *testing.T) {
 		runTracesToFoldedStacksTests(t, validInputsWithKeep)
 	})
 
+	t.Run("Line Modes", func(t *testing.T) {
+		runTracesToFoldedStacksTests(t, lineInputs)
+	})
+
+	t.Run("Frame Rules", func(t *testing.T) {
+		runTracesToFoldedStacksTests(t, ruleInputs)
+	})
+
+	t.Run("Max Depth", func(t *testing.T) {
+		runTracesToFoldedStacksTests(t, depthInputs)
+	})
+
+	t.Run("Path Normalization", func(t *testing.T) {
+		runTracesToFoldedStacksTests(t, pathInputs)
+	})
+
 	t.Run("Invalid Inputs", func(t *testing.T) {
 		runTracesToFoldedStacksTests(t, invalidInputs)
 	})
 }
+
+func TestAppendFrames(t *testing.T) {
+	frames := make([]string, 0, 4)
+
+	frames, entryPoint, _, err := transform.AppendFrames(frames, []string{
+		"0 func1 /app/helper.php:10",
+		"1 main /app/index.php:1",
+	}, transform.FoldOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"main", "func1"}, frames)
+	assert.Equal(t, "/app/index.php", entryPoint)
+
+	// the slice is reused and fields may be separated by any whitespace like strings.Fields does
+	reused, entryPoint, _, err := transform.AppendFrames(frames[:0], []string{
+		"0\tfunc2  /app/helper.php:20",
+		" 1\u00a0main\u3000/app/índex.php:1 ",
+	}, transform.FoldOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"main", "func2"}, reused)
+	assert.Equal(t, "/app/índex.php", entryPoint)
+	assert.Same(t, &frames[0], &reused[0])
+}
+
+func TestParseLineMode(t *testing.T) {
+	for name, want := range map[string]transform.LineMode{
+		"":      transform.LinesNone,
+		"none":  transform.LinesNone,
+		"frame": transform.LinesFrame,
+		"leaf":  transform.LinesLeaf,
+	} {
+		got, err := transform.ParseLineMode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, "line mode %q", name)
+	}
+
+	_, err := transform.ParseLineMode("column")
+	assert.EqualError(t, err, "invalid line mode: column")
+}
+
+func BenchmarkTracesToFrames(b *testing.B) {
+	trace := make([]string, 0, 20)
+	for i := 0; i < 19; i++ {
+		trace = append(trace, fmt.Sprintf("%d App\\Service%d::handle /app/src/Service%d.php:%d", i, i, i, i+10))
+	}
+	trace = append(trace, "19 main /app/public/index.php:1")
+
+	b.Run("TracesToFrames", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, _, err := transform.TracesToFrames(trace, transform.FoldOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("AppendFrames", func(b *testing.B) {
+		frames := make([]string, 0, len(trace)+1)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var err error
+			if frames, _, _, err = transform.AppendFrames(frames[:0], trace, transform.FoldOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
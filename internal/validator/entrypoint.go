@@ -1,17 +1,60 @@
 package validator
 
 import (
+	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 
 	"github.com/bmatcuk/doublestar/v4"
 )
 
+const (
+	// excludePrefix marks a pattern that rejects matching entry points.
+	excludePrefix = "!"
+	// regexPrefix marks a pattern that is a regular expression.
+	regexPrefix = "re:"
+)
+
+// Pattern matches entry points by suffix, doublestar glob or, with the `re:` prefix, regular expression.
+type Pattern struct {
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// ParsePattern parses an entry point pattern without the exclude prefix.
+func ParsePattern(pattern string) (Pattern, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		regex, err := regexp.Compile(pattern[len(regexPrefix):])
+		if err != nil {
+			return Pattern{}, err
+		}
+		return Pattern{pattern: pattern, regex: regex}, nil
+	}
+	return Pattern{pattern: pattern}, nil
+}
+
+// Matches checks if the entryPoint matches the pattern.
+func (p Pattern) Matches(entryPoint string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(entryPoint)
+	}
+	return matches(entryPoint, p.pattern)
+}
+
+// rule is a parsed entry point pattern of the validator.
+type rule struct {
+	Pattern
+	exclude bool
+}
+
 // EntryPointValidator validates entry points against predefined patterns with caching.
 type EntryPointValidator struct {
-	patterns []string
-	cache    Cache
-	mu       sync.RWMutex
+	rules []rule
+	// defaultValid is the result for entry points no rule matches
+	defaultValid bool
+	cache        Cache
+	mu           sync.RWMutex
 }
 
 type Cache interface {
@@ -24,12 +67,55 @@ func hasWildcard(pattern string) bool {
 	return strings.ContainsAny(pattern, "*?[")
 }
 
+// parseRule parses a pattern: `!` prefix excludes matching entry points, `re:` prefix makes it a regular expression.
+func parseRule(pattern string) (rule, error) {
+	exclude := strings.HasPrefix(pattern, excludePrefix)
+	if exclude {
+		pattern = pattern[len(excludePrefix):]
+	}
+
+	parsed, err := ParsePattern(pattern)
+	if err != nil {
+		return rule{}, err
+	}
+
+	return rule{Pattern: parsed, exclude: exclude}, nil
+}
+
+// Validate checks that all patterns can be parsed.
+func Validate(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := parseRule(pattern); err != nil {
+			return fmt.Errorf("invalid entrypoint pattern `%s`: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
 // New creates a new EntryPointValidator with the given patterns and cache driver.
+// Patterns are evaluated in order and the first matching one wins. Patterns prefixed with `!` exclude
+// matching entry points, patterns prefixed with `re:` are regular expressions, others are suffixes or doublestar globs.
+// Entry points no pattern matches are invalid if there is at least one include pattern, and valid otherwise.
+// Invalid regular expressions never match, use Validate to report them.
 func New(patterns []string, cache Cache) *EntryPointValidator {
-	return &EntryPointValidator{
-		patterns: patterns,
-		cache:    cache,
+	v := &EntryPointValidator{
+		rules:        make([]rule, 0, len(patterns)),
+		defaultValid: true,
+		cache:        cache,
 	}
+
+	for _, pattern := range patterns {
+		r, err := parseRule(pattern)
+		if err != nil {
+			continue
+		}
+		if !r.exclude {
+			v.defaultValid = false
+		}
+		v.rules = append(v.rules, r)
+	}
+
+	return v
 }
 
 // matches checks if the entryPoint matches the given pattern.
@@ -44,7 +130,7 @@ func matches(entryPoint, pattern string) bool {
 // IsValid determines if the entryPoint is valid based on the patterns.
 // It utilizes an LRU cache to store and retrieve validation results.
 func (v *EntryPointValidator) IsValid(entryPoint string) bool {
-	if len(v.patterns) == 0 {
+	if len(v.rules) == 0 {
 		return true
 	}
 
@@ -55,18 +141,19 @@ func (v *EntryPointValidator) IsValid(entryPoint string) bool {
 	}
 	v.mu.RUnlock()
 
-	// Perform pattern matching.
-	isValid := false
-	for _, pattern := range v.patterns {
-		if matches(entryPoint, pattern) {
-			isValid = true
+	// Perform pattern matching, the first matching rule wins.
+	isValid := v.defaultValid
+	for _, r := range v.rules {
+		if r.Matches(entryPoint) {
+			isValid = !r.exclude
 			break
 		}
 	}
 
 	// Store the result in cache with write lock.
+	// The entry point is copied, it may be a substring of the whole trace.
 	v.mu.Lock()
-	v.cache.Add(entryPoint, isValid)
+	v.cache.Add(strings.Clone(entryPoint), isValid)
 	v.mu.Unlock()
 
 	return isValid
@@ -96,3 +96,72 @@ func TestPHPEntryPointValidator(t *testing.T) {
 		cacheMock.AssertExpectations(t)
 	})
 }
+
+func TestEntryPointValidator_Rules(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		valid    []string
+		invalid  []string
+	}{
+		{
+			name:     "exclude only allows everything else",
+			patterns: []string{"!**/cron/*.php"},
+			valid:    []string{"/app/index.php", "/app/public/api.php", "/app/crons/cleanup.php"},
+			invalid:  []string{"cron/cleanup.php", "/app/jobs/cron/cleanup.php", "/app/cron/cleanup.php"},
+		},
+		{
+			name:     "globs match whole entry points",
+			patterns: []string{"!cron/*.php"},
+			valid:    []string{"/app/jobs/cron/cleanup.php"},
+			invalid:  []string{"cron/cleanup.php"},
+		},
+		{
+			name:     "absolute globs match whole paths",
+			patterns: []string{"/app/*/index.php"},
+			valid:    []string{"/app/public/index.php"},
+			invalid:  []string{"/srv/app/public/index.php", "/app/public/admin/index.php"},
+		},
+		{
+			name:     "first matching rule wins",
+			patterns: []string{"!/app/public/health.php", "/app/public/*.php"},
+			valid:    []string{"/app/public/index.php"},
+			invalid:  []string{"/app/public/health.php", "/app/bin/console.php"},
+		},
+		{
+			name:     "include before exclude wins",
+			patterns: []string{"/app/public/*.php", "!/app/public/health.php"},
+			valid:    []string{"/app/public/index.php", "/app/public/health.php"},
+		},
+		{
+			name:     "regex patterns",
+			patterns: []string{`!re:/(health|ping)\.php$`, `re:^/app/(public|api)/`},
+			valid:    []string{"/app/public/index.php", "/app/api/v1.php"},
+			invalid:  []string{"/app/public/health.php", "/app/api/ping.php", "/srv/app/public/index.php"},
+		},
+		{
+			name:     "invalid regex never matches",
+			patterns: []string{"re:(", "index.php"},
+			valid:    []string{"/app/index.php"},
+			invalid:  []string{"("},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New(tt.patterns, NewCacheMock())
+			for _, ep := range tt.valid {
+				assert.True(t, v.IsValid(ep), "Expected entry point '%s' to be valid", ep)
+			}
+			for _, ep := range tt.invalid {
+				assert.False(t, v.IsValid(ep), "Expected entry point '%s' to be invalid", ep)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, validator.Validate([]string{"index.php", "!cron/*.php", `re:^/app/`, `!re:health\.php$`}))
+	assert.EqualError(t, validator.Validate([]string{"index.php", "!re:("}),
+		"invalid entrypoint pattern `!re:(`: error parsing regexp: missing closing ): `(`")
+}